@@ -1,24 +1,28 @@
 package main
 
 import (
+	"archive/tar"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
 	"github.com/dustin/go-humanize"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/joomcode/errorx"
 	"github.com/joomcode/go-porter/src"
 	"github.com/mkideal/cli"
-	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,6 +32,8 @@ type CmdRootT struct {
 	ConfigFile  string `cli:"config" usage:"Configuration file" dft:"$PORTER_CONFIG"`
 	LogLevel    string `cli:"log" usage:"Log level (panic, fatal, error, warn, info, debug)" dft:"error"`
 	MemoryCache bool   `cli:"memory-cache" usage:"Keep all state changes only in memory"`
+	Offline     bool   `cli:"offline" usage:"Use only cached images, fail on any registry access"`
+	ErrorFormat string `cli:"error-format" usage:"Error output format (text, json)" dft:"$PORTER_ERROR_FORMAT"`
 }
 
 func (c CmdRootT) GetCacheDir() string {
@@ -38,6 +44,14 @@ func (c CmdRootT) GetMemoryCache() bool {
 	return c.MemoryCache
 }
 
+func (c CmdRootT) GetErrorFormat() string {
+	return c.ErrorFormat
+}
+
+func (c CmdRootT) GetOffline() bool {
+	return c.Offline
+}
+
 func (c CmdRootT) GetConfigFile() string {
 	return c.ConfigFile
 }
@@ -62,16 +76,88 @@ type cmdBuildT struct {
 	Target     string `cli:"target" usage:"Set the target build stage to build"`
 	Push       bool   `cli:"push" usage:"Push docker image after build"`
 	Platform   string `cli:"platform" usage:"Set target platform for build"`
+	Squash     bool   `cli:"squash" usage:"Squash newly built layers into a single new layer"`
+	Pin        bool   `cli:"pin" usage:"Resolve base image to digest and record it in image labels"`
+	Pull       bool   `cli:"pull" usage:"Always refresh base image manifest"`
 }
 
 type cmdLoginT struct {
 	CmdRootT
-	Password string `cli:"*p,password" usage:"Password"`
-	Username string `cli:"*u,username" usage:"Username"`
+	Password      string `cli:"p,password" usage:"Password"`
+	PasswordStdin bool   `cli:"password-stdin" usage:"Take the password from stdin"`
+	Username      string `cli:"*u,username" usage:"Username"`
+}
+
+type cmdLogoutT struct {
+	CmdRootT
+}
+
+type cmdInspectT struct {
+	CmdRootT
+	Format   string `cli:"format" usage:"Format output using a Go template"`
+	Type     string `cli:"type" usage:"Output type (image, manifest, config, raw)" dft:"image"`
+	Remote   bool   `cli:"remote" usage:"Inspect image in registry without pulling layers"`
+	Platform string `cli:"platform" usage:"Platform for remote manifest list" dft:"linux/amd64"`
+}
+
+type cmdTagsT struct {
+	CmdRootT
+	Filter string `cli:"f,filter" usage:"Show only tags matching glob pattern"`
+	Limit  int    `cli:"limit" usage:"Maximum number of tags to show (newest versions first)"`
+	Format string `cli:"format" usage:"Output format (text, json)" dft:"text"`
+}
+
+type cmdCatalogT struct {
+	CmdRootT
+	Format string `cli:"format" usage:"Output format (text, json)" dft:"text"`
+}
+
+type cmdResolveT struct {
+	CmdRootT
+	Cached bool `cli:"cached" usage:"Use cached manifest if present"`
+}
+
+type cmdDeleteT struct {
+	CmdRootT
+	Digest bool `cli:"digest" usage:"Delete manifest by digest with all its tags"`
+}
+
+type cmdRetentionT struct {
+	CmdRootT
+	Filter    string `cli:"f,filter" usage:"Apply policy only to tags matching glob pattern"`
+	KeepLast  int    `cli:"keep-last" usage:"Number of newest matching tags to keep"`
+	OlderThan string `cli:"older-than" usage:"Delete only tags older than duration (e.g. 72h, 30d)"`
+	DryRun    bool   `cli:"dry-run" usage:"Show tags to delete without deleting them"`
+	Format    string `cli:"format" usage:"Output format (table, json)" dft:"table"`
+}
+
+type cmdManifestInspectT struct {
+	CmdRootT
+	Raw      bool   `cli:"raw" usage:"Print raw manifest"`
+	Platform string `cli:"platform" usage:"Resolve manifest list to platform image"`
+	Format   string `cli:"format" usage:"Output format (text, json)" dft:"text"`
 }
 
 type cmdImageLsT struct {
 	CmdRootT
+	Format  string   `cli:"format" usage:"Format output using a Go template, 'table TEMPLATE' or 'json'"`
+	Filters []string `cli:"f,filter" usage:"Filter output (reference=GLOB, label=KEY[=VALUE], before=IMAGE, since=IMAGE, dangling=BOOL)"`
+	Digests bool     `cli:"digests" usage:"Show digests"`
+	NoTrunc bool     `cli:"no-trunc" usage:"Don't truncate output"`
+	Quiet   bool     `cli:"q,quiet" usage:"Only show image IDs"`
+}
+
+// imageRow is template data for image list output
+type imageRow struct {
+	Repository       string
+	Tag              string
+	Digest           string
+	ID               string
+	CreatedAt        string
+	CreatedSince     string
+	Size             string
+	UncompressedSize string
+	Labels           string
 }
 
 type cmdSaveT struct {
@@ -87,12 +173,109 @@ type cmdTagT struct {
 	CmdRootT
 }
 
+type cmdHistoryT struct {
+	CmdRootT
+	NoTrunc bool   `cli:"no-trunc" usage:"Don't truncate output"`
+	Format  string `cli:"format" usage:"Output format (table, json)" dft:"table"`
+}
+
+type cmdFsLsT struct {
+	CmdRootT
+}
+
+type cmdFsTreeT struct {
+	CmdRootT
+	Level int `cli:"L,level" usage:"Max display depth of the directory tree"`
+}
+
+type cmdFsStatT struct {
+	CmdRootT
+	Dereference bool `cli:"L,dereference" usage:"Follow symlinks"`
+}
+
+type cmdExportT struct {
+	CmdRootT
+	Output string `cli:"o,output" usage:"Write to a file, instead of STDOUT"`
+}
+
+type cmdCpT struct {
+	CmdRootT
+}
+
+type cmdDiffT struct {
+	CmdRootT
+	Path    string `cli:"path" usage:"Compare only entries under path prefix"`
+	Content bool   `cli:"content" usage:"Compare content of same size files from different layers"`
+	Format  string `cli:"format" usage:"Output format (text, json)" dft:"text"`
+}
+
+type cmdAnalyzeT struct {
+	CmdRootT
+	Top       int    `cli:"top" usage:"Number of entries in every report list (0 for unlimited)" dft:"10"`
+	MaxWasted string `cli:"max-wasted" usage:"Fail if wasted space exceeds limit (size like 10MB or percent of total like 5%)"`
+	Format    string `cli:"format" usage:"Output format (text, json)" dft:"text"`
+}
+
+type cmdSquashT struct {
+	CmdRootT
+	From int    `cli:"from" usage:"Index of first layer to squash"`
+	Tag  string `cli:"*t,tag" usage:"Name and optionally a tag of squashed image in the 'name:tag' format"`
+}
+
+type cmdRebaseT struct {
+	CmdRootT
+	OldBase string `cli:"*old-base" usage:"Current base image"`
+	NewBase string `cli:"*new-base" usage:"New base image"`
+	Tag     string `cli:"*t,tag" usage:"Name and optionally a tag of rebased image in the 'name:tag' format"`
+}
+
+type cmdMutateT struct {
+	CmdRootT
+	Tag         string   `cli:"*t,tag" usage:"Name and optionally a tag of new image in the 'name:tag' format"`
+	Labels      []string `cli:"label" usage:"Set label (KEY=VALUE)"`
+	Env         []string `cli:"env" usage:"Set environment variable (KEY=VALUE)"`
+	Entrypoint  string   `cli:"entrypoint" usage:"Set entrypoint (JSON array or shell form)"`
+	Cmd         string   `cli:"cmd" usage:"Set default command (JSON array or shell form)"`
+	User        string   `cli:"user" usage:"Set user"`
+	WorkingDir  string   `cli:"workdir" usage:"Set working directory"`
+	Expose      []string `cli:"expose" usage:"Expose port (PORT[/PROTOCOL])"`
+	Annotations []string `cli:"annotation" usage:"Set annotation (KEY=VALUE)"`
+}
+
+type cmdAppendT struct {
+	CmdRootT
+	Tag    string   `cli:"*t,tag" usage:"Name and optionally a tag of new image in the 'name:tag' format"`
+	Layers []string `cli:"layer" usage:"Append tarball (tar or tar.gz) as a new layer"`
+	Dirs   []string `cli:"dir" usage:"Append host directory in SOURCE:/DEST format (all directories are added as single layer after tarballs)"`
+}
+
+type cmdCopyT struct {
+	CmdRootT
+	AllPlatforms bool `cli:"all-platforms" usage:"Copy all platforms of manifest list"`
+}
+
 var root = &cli.Command{
 	Desc: "https://github.com/joomcode/go-porter",
 	Argv: func() interface{} {
 		argv := newCmdRoot()
 		return &argv
 	},
+	OnRootBefore: func(ctx *cli.Context) error {
+		if argv, ok := ctx.Argv().(interface{ GetErrorFormat() string }); ok && argv.GetErrorFormat() != "" {
+			errorFormat = argv.GetErrorFormat()
+		}
+		return nil
+	},
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		os.Exit(1)
+		return nil
+	},
+}
+
+var cmdFs = &cli.Command{
+	Name: "fs",
+	Desc: "Browse image filesystem",
 	Fn: func(ctx *cli.Context) error {
 		ctx.WriteUsage()
 		os.Exit(1)
@@ -126,6 +309,18 @@ func (c cmdBuildT) GetPlatform() string {
 	return c.Platform
 }
 
+func (c cmdBuildT) GetSquash() bool {
+	return c.Squash
+}
+
+func (c cmdBuildT) GetPin() bool {
+	return c.Pin
+}
+
+func (c cmdBuildT) GetPull() bool {
+	return c.Pull
+}
+
 func newCmdRoot() CmdRootT {
 	buildInfo, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -233,6 +428,49 @@ func NewLoginCommand(cmd string) *cli.Command {
 		CanSubRoute: true,
 		Fn: func(c *cli.Context) error {
 			argv := c.Argv().(*cmdLoginT)
+			password := argv.Password
+			if argv.PasswordStdin {
+				if password != "" {
+					return errorx.IllegalArgument.New("--password and --password-stdin are mutually exclusive")
+				}
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				password = strings.TrimRight(string(data), "\r\n")
+			}
+			if password == "" {
+				return errorx.IllegalArgument.New("password is required")
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			if err := state.Login(ctx, argv.Username, password, c.Args()[0]); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+func NewLogoutCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Log out from a Docker registry",
+		Argv: func() interface{} {
+			return &cmdLogoutT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdLogoutT)
 			ctx := context.Background()
 			state, err := src.NewState(argv)
 			if err != nil {
@@ -240,7 +478,7 @@ func NewLoginCommand(cmd string) *cli.Command {
 			}
 			defer state.Close()
 
-			if err := state.Login(ctx, argv.Username, argv.Password, c.Args()[0]); err != nil {
+			if err := state.Logout(ctx, c.Args()[0]); err != nil {
 				return err
 			}
 			return nil
@@ -278,9 +516,11 @@ func NewImageBuildCommand(cmd string) *cli.Command {
 			}
 			fmt.Println(digest)
 			if argv.Push {
-				if err := state.Push(ctx, argv.GetTag()); err != nil {
+				results, err := state.Push(ctx, argv.GetTag())
+				if err != nil {
 					return err
 				}
+				printPushResults(results)
 			}
 			return nil
 		},
@@ -306,38 +546,126 @@ func NewImageListCommand(cmd string) *cli.Command {
 			}
 			defer state.Close()
 
-			images, err := state.GetImages(ctx)
+			images, err := state.ListImages(ctx, argv.Filters...)
 			if err != nil {
 				return err
 			}
 
-			w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
-			fmt.Fprintln(w, strings.Join([]string{
-				"REPOSITORY",
-				"TAG",
-				"IMAGE ID",
-				"SIZE",
-			}, "\t"))
-			var lines []string
-			for image, manifest := range images {
-				var size int64
-				for _, layer := range manifest.Layers {
-					size += layer.Size
-				}
-				lines = append(lines, strings.Join([]string{
-					image.Context().RegistryStr() + "/" + image.Context().RepositoryStr(),
-					image.Identifier(),
-					manifest.Config.Digest.Hex()[0:12],
-					humanize.Bytes(uint64(size)),
-				}, "\t"))
+			rows := make([]imageRow, 0, len(images))
+			for _, image := range images {
+				rows = append(rows, newImageRow(image, argv.NoTrunc))
 			}
-			for _, line := range lines {
-				fmt.Fprintln(w, line)
+
+			if argv.Quiet {
+				for _, row := range rows {
+					fmt.Println(row.ID)
+				}
+				return nil
 			}
-			w.Flush()
-			return nil
+
+			format := argv.Format
+			switch {
+			case format == "json":
+				if images == nil {
+					images = []src.ImageSummary{}
+				}
+				payload, err := json.MarshalIndent(images, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+				return nil
+			case format == "" || format == "table":
+				columns := []string{"{{.Repository}}", "{{.Tag}}"}
+				if argv.Digests {
+					columns = append(columns, "{{.Digest}}")
+				}
+				columns = append(columns, "{{.ID}}", "{{.CreatedSince}}", "{{.Size}}", "{{.UncompressedSize}}")
+				format = "table " + strings.Join(columns, "\t")
+			}
+			return printTemplate(format, rows, imageRow{
+				Repository:       "REPOSITORY",
+				Tag:              "TAG",
+				Digest:           "DIGEST",
+				ID:               "IMAGE ID",
+				CreatedAt:        "CREATED AT",
+				CreatedSince:     "CREATED",
+				Size:             "SIZE",
+				UncompressedSize: "UNCOMPRESSED",
+				Labels:           "LABELS",
+			})
+		},
+	}
+}
+
+func newImageRow(image src.ImageSummary, noTrunc bool) imageRow {
+	row := imageRow{
+		Repository:       image.Repository,
+		Tag:              image.Tag,
+		Digest:           image.Digest.String(),
+		ID:               image.ID.String(),
+		CreatedAt:        "<unknown>",
+		CreatedSince:     "<unknown>",
+		Size:             humanize.Bytes(uint64(image.Size)),
+		UncompressedSize: "-",
+	}
+	if !noTrunc {
+		row.ID = image.ID.Encoded()[:12]
+	}
+	if !image.Created.IsZero() {
+		row.CreatedAt = image.Created.Format("2006-01-02 15:04:05 -0700 MST")
+		row.CreatedSince = humanize.Time(image.Created)
+	}
+	if image.UncompressedSize > 0 {
+		row.UncompressedSize = humanize.Bytes(uint64(image.UncompressedSize))
+	}
+	labels := make([]string, 0, len(image.Labels))
+	for key, value := range image.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	row.Labels = strings.Join(labels, ",")
+	return row
+}
+
+// printTemplate writes items using Go template. Template with "table " prefix is aligned by columns with header.
+func printTemplate[T any](format string, items []T, header T) error {
+	table := strings.HasPrefix(format, "table ")
+	format = strings.ReplaceAll(strings.TrimPrefix(format, "table "), `\t`, "\t")
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			payload, err := json.Marshal(v)
+			return string(payload), err
 		},
+		"join":  strings.Join,
+		"split": strings.Split,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}).Parse(format)
+	if err != nil {
+		return errorx.IllegalArgument.Wrap(err, "invalid format template")
+	}
+
+	var out io.Writer = os.Stdout
+	var w *tabwriter.Writer
+	if table {
+		w = tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+		out = w
+		if err := tmpl.Execute(out, header); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
 	}
+	for _, item := range items {
+		if err := tmpl.Execute(out, item); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	if w != nil {
+		return w.Flush()
+	}
+	return nil
 }
 
 func NewImageInspectCommand(cmd string) *cli.Command {
@@ -345,14 +673,15 @@ func NewImageInspectCommand(cmd string) *cli.Command {
 		Name: cmd,
 		Desc: "Return low-level information on Docker objects",
 		Argv: func() interface{} {
-			return &cmdImageLsT{
+			return &cmdInspectT{
 				CmdRootT: newCmdRoot(),
+				Type:     "image",
 			}
 		},
 		NumArg:      cli.AtLeast(1),
 		CanSubRoute: true,
 		Fn: func(c *cli.Context) error {
-			argv := c.Argv().(*cmdImageLsT)
+			argv := c.Argv().(*cmdInspectT)
 			ctx := context.Background()
 			state, err := src.NewState(argv)
 			if err != nil {
@@ -360,7 +689,38 @@ func NewImageInspectCommand(cmd string) *cli.Command {
 			}
 			defer state.Close()
 
-			inspectedByID := make(map[digest.Digest]*types.ImageInspect)
+			switch argv.Type {
+			case "image":
+			case "manifest", "config", "raw":
+				if argv.Remote {
+					return errorx.IllegalArgument.New("type %s is not supported for remote images", argv.Type)
+				}
+				for _, image := range c.Args() {
+					info, err := name.ParseReference(image)
+					if err != nil {
+						return err
+					}
+					output, err := state.InspectRaw(ctx, info, argv.Type)
+					if err != nil {
+						return err
+					}
+					if _, err := os.Stdout.Write(append(output, '\n')); err != nil {
+						return err
+					}
+				}
+				return nil
+			default:
+				return errorx.IllegalArgument.New("unsupported type: %s", argv.Type)
+			}
+
+			var platform *v1.Platform
+			if argv.Remote {
+				if platform, err = v1.ParsePlatform(argv.Platform); err != nil {
+					return err
+				}
+			}
+
+			inspectedByID := make(map[string]*types.ImageInspect)
 			inspected := make([]*types.ImageInspect, 0, len(c.Args()))
 			for _, image := range c.Args() {
 				info, err := name.ParseReference(image)
@@ -368,62 +728,41 @@ func NewImageInspectCommand(cmd string) *cli.Command {
 					return err
 				}
 
-				manifest, err := state.LoadManifest(ctx, info)
+				var inspect *types.ImageInspect
+				if argv.Remote {
+					inspect, err = state.InspectRemoteImage(ctx, info, platform)
+				} else {
+					inspect, err = state.InspectImage(ctx, info)
+				}
 				if err != nil {
 					return err
 				}
-				if manifest == nil {
-					return errorx.IllegalArgument.New("image not found: %s", info.Name())
-				}
-
-				inspect := inspectedByID[manifest.Config.Digest]
-				if inspect == nil {
-					var imageManifest v1.ConfigFile
-					configBlob, err := state.ReadBlob(ctx, manifest.Config)
-					if err != nil {
-						return err
-					}
-					if err := json.Unmarshal(configBlob, &imageManifest); err != nil {
-						return err
+				// Same image is shown once
+				if existing, ok := inspectedByID[inspect.ID]; ok {
+					for _, repoTag := range inspect.RepoTags {
+						if !containsString(existing.RepoTags, repoTag) {
+							existing.RepoTags = append(existing.RepoTags, repoTag)
+						}
 					}
-					layers := make([]string, 0, len(imageManifest.RootFS.DiffIDs))
-					for _, layer := range imageManifest.RootFS.DiffIDs {
-						layers = append(layers, layer.String())
+					for _, repoDigest := range inspect.RepoDigests {
+						if !containsString(existing.RepoDigests, repoDigest) {
+							existing.RepoDigests = append(existing.RepoDigests, repoDigest)
+						}
 					}
-					var size int64
-					for _, layer := range manifest.Layers {
-						size += layer.Size
-					}
-					inspect = &types.ImageInspect{
-						ID:            manifest.Config.Digest.String(),
-						Created:       imageManifest.Created.String(),
-						DockerVersion: imageManifest.DockerVersion,
-						Architecture:  imageManifest.Architecture,
-						Author:        imageManifest.Author,
-						Os:            imageManifest.OS,
-						OsVersion:     imageManifest.OSVersion,
-						Config: &container.Config{
-							Env:         imageManifest.Config.Env,
-							Cmd:         imageManifest.Config.Cmd,
-							ArgsEscaped: imageManifest.Config.ArgsEscaped,
-							Entrypoint:  imageManifest.Config.Entrypoint,
-							WorkingDir:  imageManifest.Config.WorkingDir,
-							Labels:      imageManifest.Config.Labels,
-							User:        imageManifest.Config.User,
-						},
-						RootFS: types.RootFS{
-							Type:   imageManifest.RootFS.Type,
-							Layers: layers,
-						},
-						Size:        size,
-						VirtualSize: size,
-					}
-					inspected = append(inspected, inspect)
-					inspectedByID[manifest.Config.Digest] = inspect
+					continue
 				}
+				inspectedByID[inspect.ID] = inspect
 				inspected = append(inspected, inspect)
-				inspect.RepoTags = append(inspect.RepoTags, info.String())
 			}
+
+			if argv.Format != "" {
+				// Inspect output has no columns for table header
+				if strings.HasPrefix(argv.Format, "table ") {
+					return errorx.IllegalArgument.New("table format is not supported by inspect")
+				}
+				return printTemplate(argv.Format, inspected, nil)
+			}
+
 			payload, err := json.MarshalIndent(inspected, "", "    ")
 			if err != nil {
 				return err
@@ -434,6 +773,15 @@ func NewImageInspectCommand(cmd string) *cli.Command {
 	}
 }
 
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 func NewImageSaveCommand(cmd string) *cli.Command {
 	return &cli.Command{
 		Name: cmd,
@@ -494,14 +842,22 @@ func NewImagePushCommand(cmd string) *cli.Command {
 			}
 			defer state.Close()
 
-			if err := state.Push(ctx, c.Args()...); err != nil {
+			results, err := state.Push(ctx, c.Args()...)
+			if err != nil {
 				return err
 			}
+			printPushResults(results)
 			return nil
 		},
 	}
 }
 
+func printPushResults(results []src.PushResult) {
+	for _, result := range results {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: uploaded %s of %s\n", result.Image.Name(), humanize.Bytes(uint64(result.Uploaded)), humanize.Bytes(uint64(result.Size)))
+	}
+}
+
 func NewImageTagCommand(cmd string) *cli.Command {
 	return &cli.Command{
 		Name: cmd,
@@ -530,30 +886,1209 @@ func NewImageTagCommand(cmd string) *cli.Command {
 	}
 }
 
-func main() {
-	cli.SetUsageStyle(cli.ManualStyle)
-	if err := cli.Root(root,
-		cli.Tree(NewImageBuildCommand("build")),
-		cli.Tree(NewImageListCommand("images")),
-		cli.Tree(NewImageInspectCommand("inspect")),
-		cli.Tree(cmdImage,
-			cli.Tree(NewImageBuildCommand("build")),
-			cli.Tree(NewImageInspectCommand("inspect")),
-			cli.Tree(NewImageListCommand("ls")),
-			cli.Tree(NewImagePullCommand("pull")),
-			cli.Tree(NewImagePushCommand("push")),
-			cli.Tree(NewImageRemoveCommand("rm")),
-			cli.Tree(NewImageSaveCommand("save")),
-			cli.Tree(NewImageTagCommand("tag")),
-		),
-		cli.Tree(NewLoginCommand("login")),
-		cli.Tree(NewImagePullCommand("pull")),
-		cli.Tree(NewImagePushCommand("push")),
-		cli.Tree(NewImageRemoveCommand("rmi")),
-		cli.Tree(NewImageSaveCommand("save")),
-		cli.Tree(NewImageTagCommand("tag")),
-	).Run(os.Args[1:]); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+func NewImageHistoryCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Show the history of an image",
+		Argv: func() interface{} {
+			return &cmdHistoryT{
+				CmdRootT: newCmdRoot(),
+				Format:   "table",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdHistoryT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			history, err := state.History(ctx, image)
+			if err != nil {
+				return err
+			}
+
+			switch argv.Format {
+			case "json":
+				payload, err := json.MarshalIndent(history, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+				return nil
+			case "table":
+			default:
+				return errorx.IllegalArgument.New("unsupported format: %s", argv.Format)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+			fmt.Fprintln(w, strings.Join([]string{
+				"CREATED",
+				"CREATED BY",
+				"SIZE",
+				"UNCOMPRESSED",
+				"DIFF ID",
+			}, "\t"))
+			for _, item := range history {
+				createdBy := strings.Join(strings.Fields(item.CreatedBy), " ")
+				diffID := item.DiffID
+				created := "<missing>"
+				if !item.Created.IsZero() {
+					created = humanize.Time(item.Created)
+				}
+				if !argv.NoTrunc {
+					if runes := []rune(createdBy); len(runes) > 45 {
+						createdBy = string(runes[:44]) + "…"
+					}
+					if hash, err := v1.NewHash(diffID); err == nil {
+						diffID = hash.Hex[:12]
+					}
+				}
+				fmt.Fprintln(w, strings.Join([]string{
+					created,
+					createdBy,
+					humanize.Bytes(uint64(item.Size)),
+					humanize.Bytes(uint64(item.UncompressedSize)),
+					diffID,
+				}, "\t"))
+			}
+			w.Flush()
+			return nil
+		},
+	}
+}
+
+func loadImageFS(ctx context.Context, state *src.State, image string) (*src.ImageFS, error) {
+	info, err := name.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	return state.ImageFS(ctx, info)
+}
+
+func formatLayer(fs *src.ImageFS, node *src.TreeNode) string {
+	index, ok := fs.Layer(node)
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("#%d %s", index, fs.Layers[index].Digest.Hex()[0:12])
+}
+
+func formatEntryName(entryName string, node *src.TreeNode) string {
+	if node.Typeflag == tar.TypeSymlink || node.Typeflag == tar.TypeLink {
+		return entryName + " -> " + node.Linkname
+	}
+	return entryName
+}
+
+func NewFsLsCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "List image directory contents",
+		Text: "Usage: porter fs ls IMAGE [PATH]",
+		Argv: func() interface{} {
+			return &cmdFsLsT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.AtLeast(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdFsLsT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			fs, err := loadImageFS(ctx, state, c.Args()[0])
+			if err != nil {
+				return err
+			}
+			target := "/"
+			if len(c.Args()) > 1 {
+				target = c.Args()[1]
+			}
+			resolved, node, err := fs.Lookup(target, true)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+			fmt.Fprintln(w, strings.Join([]string{
+				"MODE",
+				"OWNER",
+				"SIZE",
+				"LAYER",
+				"NAME",
+			}, "\t"))
+			printEntry := func(entryName string, node *src.TreeNode) {
+				fmt.Fprintln(w, strings.Join([]string{
+					node.FileInfo().Mode().String(),
+					fmt.Sprintf("%d:%d", node.Uid, node.Gid),
+					humanize.Bytes(uint64(node.Size)),
+					formatLayer(fs, node),
+					formatEntryName(entryName, node),
+				}, "\t"))
+			}
+			if node.Typeflag == tar.TypeDir {
+				for _, childName := range node.Names() {
+					printEntry(childName, node.Child[childName])
+				}
+			} else {
+				printEntry(resolved, node)
+			}
+			w.Flush()
+			return nil
+		},
+	}
+}
+
+func NewFsTreeCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "List image directory contents in a tree-like format",
+		Text: "Usage: porter fs tree IMAGE [PATH]",
+		Argv: func() interface{} {
+			return &cmdFsTreeT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.AtLeast(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdFsTreeT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			fs, err := loadImageFS(ctx, state, c.Args()[0])
+			if err != nil {
+				return err
+			}
+			target := "/"
+			if len(c.Args()) > 1 {
+				target = c.Args()[1]
+			}
+			resolved, node, err := fs.Lookup(target, true)
+			if err != nil {
+				return err
+			}
+
+			dirs := 0
+			files := 0
+			var printTree func(node *src.TreeNode, prefix string, level int)
+			printTree = func(node *src.TreeNode, prefix string, level int) {
+				if argv.Level > 0 && level > argv.Level {
+					return
+				}
+				names := node.Names()
+				for i, childName := range names {
+					child := node.Child[childName]
+					branch, indent := "├── ", "│   "
+					if i == len(names)-1 {
+						branch, indent = "└── ", "    "
+					}
+					fmt.Println(prefix + branch + formatEntryName(childName, child))
+					if child.Typeflag == tar.TypeDir {
+						dirs++
+						printTree(child, prefix+indent, level+1)
+					} else {
+						files++
+					}
+				}
+			}
+			fmt.Println(resolved)
+			if node.Typeflag == tar.TypeDir {
+				printTree(node, "", 1)
+			}
+			fmt.Printf("\n%d directories, %d files\n", dirs, files)
+			return nil
+		},
+	}
+}
+
+func NewFsStatCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Display image file status",
+		Text: "Usage: porter fs stat IMAGE PATH",
+		Argv: func() interface{} {
+			return &cmdFsStatT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(2),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdFsStatT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			fs, err := loadImageFS(ctx, state, c.Args()[0])
+			if err != nil {
+				return err
+			}
+			resolved, node, err := fs.Lookup(c.Args()[1], argv.Dereference)
+			if err != nil {
+				return err
+			}
+
+			owner := fmt.Sprintf("%d:%d", node.Uid, node.Gid)
+			if node.Uname != "" || node.Gname != "" {
+				owner += fmt.Sprintf(" (%s:%s)", node.Uname, node.Gname)
+			}
+			layer := "-"
+			if index, ok := fs.Layer(node); ok {
+				layer = fmt.Sprintf("#%d %s", index, fs.Layers[index].Digest)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 1, 0, 1, ' ', 0)
+			fmt.Fprintf(w, "File:\t%s\n", formatEntryName(resolved, node))
+			fmt.Fprintf(w, "Mode:\t%s\n", node.FileInfo().Mode())
+			fmt.Fprintf(w, "Owner:\t%s\n", owner)
+			fmt.Fprintf(w, "Size:\t%d\n", node.Size)
+			fmt.Fprintf(w, "Modify:\t%s\n", node.ModTime)
+			fmt.Fprintf(w, "Layer:\t%s\n", layer)
+			w.Flush()
+			return nil
+		},
+	}
+}
+
+func NewExportCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Export flattened image filesystem as a tar archive (streamed to STDOUT by default)",
+		Argv: func() interface{} {
+			return &cmdExportT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdExportT)
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			w := os.Stdout
+			if argv.Output != "" {
+				f, err := os.Create(argv.Output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			if w == nil {
+				return errorx.IllegalArgument.New("stdout is not exists")
+			}
+			return state.Export(ctx, image, w)
+		},
+	}
+}
+
+func NewCpCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Copy files/folders from a cached image to the local filesystem",
+		Text: "Usage: porter cp IMAGE:SRC_PATH DEST_PATH",
+		Argv: func() interface{} {
+			return &cmdCpT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(2),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdCpT)
+			source := c.Args()[0]
+			sep := strings.Index(source, ":/")
+			if sep < 0 {
+				return errorx.IllegalArgument.New("source must be in IMAGE:/PATH format: %s", source)
+			}
+			image, err := name.ParseReference(source[:sep])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			return state.CopyFromImage(ctx, image, source[sep+1:], c.Args()[1])
+		},
+	}
+}
+
+func NewImageCopyCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Copy SOURCE_IMAGE to TARGET_IMAGE from registry to registry",
+		Argv: func() interface{} {
+			return &cmdCopyT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(2),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdCopyT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			digest, err := state.Copy(ctx, c.Args()[0], c.Args()[1], argv.AllPlatforms)
+			if err != nil {
+				return err
+			}
+			fmt.Println(digest)
+			return nil
+		},
+	}
+}
+
+func NewDiffCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Show filesystem changes between two cached images",
+		Text: "Usage: porter diff IMAGE_A IMAGE_B",
+		Argv: func() interface{} {
+			return &cmdDiffT{
+				CmdRootT: newCmdRoot(),
+				Format:   "text",
+			}
+		},
+		NumArg:      cli.ExactN(2),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdDiffT)
+			imageA, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			imageB, err := name.ParseReference(c.Args()[1])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			entries, err := state.Diff(ctx, imageA, imageB, argv.Path, argv.Content)
+			if err != nil {
+				return err
+			}
+
+			switch argv.Format {
+			case "json":
+				if entries == nil {
+					entries = []src.DiffEntry{}
+				}
+				payload, err := json.MarshalIndent(entries, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+				return nil
+			case "text":
+			default:
+				return errorx.IllegalArgument.New("unsupported format: %s", argv.Format)
+			}
+
+			for _, entry := range entries {
+				switch entry.Kind {
+				case src.DiffAdded:
+					fmt.Printf("A %s\n", entry.Path)
+				case src.DiffRemoved:
+					fmt.Printf("D %s\n", entry.Path)
+				case src.DiffModified:
+					fmt.Printf("M %s (%s)\n", entry.Path, strings.Join(entry.Changes, ", "))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func NewAnalyzeCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Analyze space wasted by image layers",
+		Argv: func() interface{} {
+			return &cmdAnalyzeT{
+				CmdRootT: newCmdRoot(),
+				Top:      10,
+				Format:   "text",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdAnalyzeT)
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			report, err := state.Analyze(ctx, image, argv.Top)
+			if err != nil {
+				return err
+			}
+
+			switch argv.Format {
+			case "json":
+				payload, err := json.MarshalIndent(report, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+			case "text":
+				printAnalyzeReport(report)
+			default:
+				return errorx.IllegalArgument.New("unsupported format: %s", argv.Format)
+			}
+
+			if argv.MaxWasted != "" {
+				return report.CheckWasted(argv.MaxWasted)
+			}
+			return nil
+		},
+	}
+}
+
+func printAnalyzeReport(report *src.AnalyzeReport) {
+	fmt.Printf("Image:        %s\n", report.Image)
+	fmt.Printf("Total size:   %s\n", humanize.Bytes(uint64(report.TotalSize)))
+	fmt.Printf("Image size:   %s\n", humanize.Bytes(uint64(report.ImageSize)))
+	fmt.Printf("Wasted space: %s\n", humanize.Bytes(uint64(report.WastedSize)))
+	fmt.Printf("Efficiency:   %.2f%%\n", report.Efficiency*100)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tDIGEST\tFILES\tSIZE\tWASTED")
+	for _, layer := range report.Layers {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n", layer.Index, layer.Digest.Encoded()[:12], layer.Files, humanize.Bytes(uint64(layer.Size)), humanize.Bytes(uint64(layer.WastedSize)))
+	}
+	w.Flush()
+
+	if len(report.Wasted) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "WASTED\tLAYER\tBY LAYER\tREASON\tPATH")
+		for _, wasted := range report.Wasted {
+			reason := "overwritten"
+			if wasted.Deleted {
+				reason = "deleted"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", humanize.Bytes(uint64(wasted.Size)), wasted.Layer, wasted.By, reason, wasted.Path)
+		}
+		w.Flush()
+	}
+
+	if len(report.Duplicates) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "TOTAL SIZE\tLAYERS\tPATH")
+		for _, duplicate := range report.Duplicates {
+			layers := make([]string, 0, len(duplicate.Layers))
+			for _, layer := range duplicate.Layers {
+				layers = append(layers, strconv.Itoa(layer))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", humanize.Bytes(uint64(duplicate.TotalSize)), strings.Join(layers, ","), duplicate.Path)
+		}
+		w.Flush()
+	}
+
+	if len(report.Directories) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "SIZE\tFILES\tDIRECTORY")
+		for _, directory := range report.Directories {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", humanize.Bytes(uint64(directory.Size)), directory.Files, directory.Path)
+		}
+		w.Flush()
+	}
+}
+
+func NewSquashCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Squash image layers into a single layer",
+		Argv: func() interface{} {
+			return &cmdSquashT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdSquashT)
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			target, err := name.ParseReference(argv.Tag)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			manifest, err := state.Squash(ctx, image, target, argv.From)
+			if err != nil {
+				return err
+			}
+			fmt.Println(manifest.Config.Digest)
+			return nil
+		},
+	}
+}
+
+func NewRebaseCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Replace base image layers of an image",
+		Argv: func() interface{} {
+			return &cmdRebaseT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdRebaseT)
+			var refs []name.Reference
+			for _, ref := range []string{c.Args()[0], argv.OldBase, argv.NewBase, argv.Tag} {
+				parsed, err := name.ParseReference(ref)
+				if err != nil {
+					return err
+				}
+				refs = append(refs, parsed)
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			manifest, err := state.Rebase(ctx, refs[0], refs[1], refs[2], refs[3])
+			if err != nil {
+				return err
+			}
+			fmt.Println(manifest.Config.Digest)
+			return nil
+		},
+	}
+}
+
+func NewMutateCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Change image configuration without changing layers",
+		Argv: func() interface{} {
+			return &cmdMutateT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdMutateT)
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			target, err := name.ParseReference(argv.Tag)
+			if err != nil {
+				return err
+			}
+
+			options := src.MutateOptions{
+				Env:          argv.Env,
+				ExposedPorts: argv.Expose,
+			}
+			if options.Labels, err = parseKeyValues(argv.Labels); err != nil {
+				return err
+			}
+			if options.Annotations, err = parseKeyValues(argv.Annotations); err != nil {
+				return err
+			}
+			if c.IsSet("--entrypoint") {
+				options.Entrypoint = &argv.Entrypoint
+			}
+			if c.IsSet("--cmd") {
+				options.Cmd = &argv.Cmd
+			}
+			if c.IsSet("--user") {
+				options.User = &argv.User
+			}
+			if c.IsSet("--workdir") {
+				options.WorkingDir = &argv.WorkingDir
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			manifest, err := state.Mutate(ctx, image, target, options)
+			if err != nil {
+				return err
+			}
+			fmt.Println(manifest.Config.Digest)
+			return nil
+		},
+	}
+}
+
+func parseKeyValues(items []string) (map[string]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(items))
+	for _, item := range items {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			return nil, errorx.IllegalArgument.New("value must be in KEY=VALUE format: %s", item)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+func NewAppendCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Append tarballs and directories as new layers on top of base image",
+		Argv: func() interface{} {
+			return &cmdAppendT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdAppendT)
+			target, err := name.ParseReference(argv.Tag)
+			if err != nil {
+				return err
+			}
+			if len(argv.Layers) == 0 && len(argv.Dirs) == 0 {
+				return errorx.IllegalArgument.New("at least one --layer or --dir is required")
+			}
+			dirs := make([]src.AppendDir, 0, len(argv.Dirs))
+			for _, dir := range argv.Dirs {
+				sep := strings.LastIndex(dir, ":")
+				if sep < 0 || !path.IsAbs(dir[sep+1:]) {
+					return errorx.IllegalArgument.New("directory must be in SOURCE:/DEST format: %s", dir)
+				}
+				dirs = append(dirs, src.AppendDir{
+					Source: dir[:sep],
+					Dest:   dir[sep+1:],
+				})
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			manifest, err := state.Append(ctx, c.Args()[0], argv.Layers, dirs, target)
+			if err != nil {
+				return err
+			}
+			fmt.Println(manifest.Config.Digest)
+			return nil
+		},
+	}
+}
+
+var cmdManifest = &cli.Command{
+	Name: "manifest",
+	Desc: "Inspect image manifests in registry",
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		os.Exit(1)
+		return nil
+	},
+}
+
+func NewManifestInspectCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Show image manifest or manifest list from registry without pulling layers",
+		Argv: func() interface{} {
+			return &cmdManifestInspectT{
+				CmdRootT: newCmdRoot(),
+				Format:   "text",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdManifestInspectT)
+			image, err := name.ParseReference(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			var platform *v1.Platform
+			if argv.Platform != "" {
+				if platform, err = v1.ParsePlatform(argv.Platform); err != nil {
+					return err
+				}
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			remoteImage, err := state.GetRemote(ctx, image, platform)
+			if err != nil {
+				return err
+			}
+			if argv.Raw {
+				_, err := os.Stdout.Write(append(remoteImage.RawManifest, '\n'))
+				return err
+			}
+
+			switch argv.Format {
+			case "json":
+				payload, err := json.MarshalIndent(remoteImage, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+				return nil
+			case "text":
+				printRemoteImage(remoteImage)
+				return nil
+			default:
+				return errorx.IllegalArgument.New("unsupported format: %s", argv.Format)
+			}
+		},
+	}
+}
+
+func NewTagsCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "List repository tags in registry",
+		Argv: func() interface{} {
+			return &cmdTagsT{
+				CmdRootT: newCmdRoot(),
+				Format:   "text",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdTagsT)
+			repo, err := name.NewRepository(c.Args()[0])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			tags, err := state.ListTags(ctx, repo, argv.Filter, argv.Limit)
+			if err != nil {
+				return err
+			}
+			return printList(argv.Format, tags)
+		},
+	}
+}
+
+func NewCatalogCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "List repositories in registry",
+		Argv: func() interface{} {
+			return &cmdCatalogT{
+				CmdRootT: newCmdRoot(),
+				Format:   "text",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdCatalogT)
+			registry, err := name.NewRegistry(c.Args()[0])
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			repos, err := state.Catalog(ctx, registry)
+			if err != nil {
+				return err
+			}
+			return printList(argv.Format, repos)
+		},
+	}
+}
+
+func NewResolveCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Resolve image reference to manifest digest",
+		Argv: func() interface{} {
+			return &cmdResolveT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.AtLeast(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdResolveT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			for _, arg := range c.Args() {
+				image, err := name.ParseReference(arg)
+				if err != nil {
+					return err
+				}
+				resolved, err := state.Resolve(ctx, image, argv.Cached)
+				if err != nil {
+					return err
+				}
+				fmt.Println(resolved.Name())
+			}
+			return nil
+		},
+	}
+}
+
+func NewDeleteCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Delete image tag or manifest from registry",
+		Argv: func() interface{} {
+			return &cmdDeleteT{
+				CmdRootT: newCmdRoot(),
+			}
+		},
+		NumArg:      cli.AtLeast(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdDeleteT)
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			for _, arg := range c.Args() {
+				image, err := name.ParseReference(arg)
+				if err != nil {
+					return err
+				}
+				deleted, err := state.Delete(ctx, image, argv.Digest)
+				if err != nil {
+					return err
+				}
+				fmt.Println(deleted.Name())
+			}
+			return nil
+		},
+	}
+}
+
+func NewRetentionCommand(cmd string) *cli.Command {
+	return &cli.Command{
+		Name: cmd,
+		Desc: "Delete old repository tags by retention policy",
+		Argv: func() interface{} {
+			return &cmdRetentionT{
+				CmdRootT: newCmdRoot(),
+				Format:   "table",
+			}
+		},
+		NumArg:      cli.ExactN(1),
+		CanSubRoute: true,
+		Fn: func(c *cli.Context) error {
+			argv := c.Argv().(*cmdRetentionT)
+			repo, err := name.NewRepository(c.Args()[0])
+			if err != nil {
+				return err
+			}
+			policy := src.RetentionPolicy{
+				Pattern:  argv.Filter,
+				KeepLast: argv.KeepLast,
+			}
+			if argv.OlderThan != "" {
+				if policy.OlderThan, err = parseAge(argv.OlderThan); err != nil {
+					return err
+				}
+			}
+
+			ctx := context.Background()
+			state, err := src.NewState(argv)
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			items, err := state.Retention(ctx, repo, policy, argv.DryRun)
+			if err != nil {
+				return err
+			}
+
+			switch argv.Format {
+			case "json":
+				payload, err := json.MarshalIndent(items, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+				return nil
+			case "table":
+			default:
+				return errorx.IllegalArgument.New("unsupported format: %s", argv.Format)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "TAG\tDIGEST\tCREATED\tACTION")
+			for _, item := range items {
+				action := "keep"
+				if item.Delete {
+					action = "delete"
+					if argv.DryRun {
+						action = "delete (dry run)"
+					}
+				}
+				created := "-"
+				if !item.Created.IsZero() {
+					created = humanize.Time(item.Created)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.Tag, item.Digest, created, action)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// parseAge parses duration with additional day unit (e.g. 30d).
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errorx.IllegalArgument.Wrap(err, "invalid duration: %s", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errorx.IllegalArgument.Wrap(err, "invalid duration: %s", value)
+	}
+	return duration, nil
+}
+
+// printList prints items one per line or as JSON array.
+func printList(format string, items []string) error {
+	switch format {
+	case "json":
+		if items == nil {
+			items = []string{}
+		}
+		payload, err := json.MarshalIndent(items, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(payload))
+	case "text":
+		for _, item := range items {
+			fmt.Println(item)
+		}
+	default:
+		return errorx.IllegalArgument.New("unsupported format: %s", format)
+	}
+	return nil
+}
+
+func printRemoteImage(image *src.RemoteImage) {
+	fmt.Printf("Name:       %s\n", image.Name)
+	fmt.Printf("Media type: %s\n", image.MediaType)
+	fmt.Printf("Digest:     %s\n", image.Digest)
+	if image.Index != nil {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "PLATFORM\tDIGEST\tSIZE\tMEDIA TYPE")
+		for _, manifest := range image.Index.Manifests {
+			platform := "-"
+			if manifest.Platform != nil {
+				platform = manifest.Platform.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", platform, manifest.Digest, humanize.Bytes(uint64(manifest.Size)), manifest.MediaType)
+		}
+		w.Flush()
+		return
+	}
+
+	configFile := image.ConfigFile
+	if platform := configFile.Platform(); platform != nil {
+		fmt.Printf("Platform:   %s\n", platform)
+	}
+	if !configFile.Created.IsZero() {
+		fmt.Printf("Created:    %s\n", configFile.Created.Format(time.RFC3339))
+	}
+	fmt.Printf("Config:     %s\n", image.Manifest.Config.Digest)
+	var size int64
+	for _, layer := range image.Manifest.Layers {
+		size += layer.Size
+	}
+	fmt.Printf("Size:       %s\n", humanize.Bytes(uint64(size)))
+	if len(configFile.Config.Labels) > 0 {
+		fmt.Println("Labels:")
+		keys := make([]string, 0, len(configFile.Config.Labels))
+		for key := range configFile.Config.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("  %s=%s\n", key, configFile.Config.Labels[key])
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 1, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tDIGEST\tSIZE\tMEDIA TYPE")
+	for index, layer := range image.Manifest.Layers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", index, layer.Digest, humanize.Bytes(uint64(layer.Size)), layer.MediaType)
+	}
+	w.Flush()
+}
+
+// Error output format, can be overridden by --error-format option
+var errorFormat = os.Getenv("PORTER_ERROR_FORMAT")
+
+func main() {
+	cli.SetUsageStyle(cli.ManualStyle)
+	if err := cli.Root(root,
+		cli.Tree(NewAnalyzeCommand("analyze")),
+		cli.Tree(NewAppendCommand("append")),
+		cli.Tree(NewImageBuildCommand("build")),
+		cli.Tree(NewImageListCommand("images")),
+		cli.Tree(NewImageInspectCommand("inspect")),
+		cli.Tree(NewImageCopyCommand("copy")),
+		cli.Tree(NewCpCommand("cp")),
+		cli.Tree(NewDiffCommand("diff")),
+		cli.Tree(NewExportCommand("export")),
+		cli.Tree(NewImageHistoryCommand("history")),
+		cli.Tree(cmdImage,
+			cli.Tree(NewAppendCommand("append")),
+			cli.Tree(NewImageBuildCommand("build")),
+			cli.Tree(NewImageCopyCommand("copy")),
+			cli.Tree(NewImageHistoryCommand("history")),
+			cli.Tree(NewImageInspectCommand("inspect")),
+			cli.Tree(NewImageListCommand("ls")),
+			cli.Tree(NewMutateCommand("mutate")),
+			cli.Tree(NewImagePullCommand("pull")),
+			cli.Tree(NewImagePushCommand("push")),
+			cli.Tree(NewRebaseCommand("rebase")),
+			cli.Tree(NewImageRemoveCommand("rm")),
+			cli.Tree(NewImageSaveCommand("save")),
+			cli.Tree(NewSquashCommand("squash")),
+			cli.Tree(NewImageTagCommand("tag")),
+		),
+		cli.Tree(cmdFs,
+			cli.Tree(NewFsLsCommand("ls")),
+			cli.Tree(NewFsStatCommand("stat")),
+			cli.Tree(NewFsTreeCommand("tree")),
+		),
+		cli.Tree(NewLoginCommand("login")),
+		cli.Tree(NewLogoutCommand("logout")),
+		cli.Tree(NewResolveCommand("resolve")),
+		cli.Tree(NewTagsCommand("tags")),
+		cli.Tree(NewDeleteCommand("delete")),
+		cli.Tree(NewRetentionCommand("retention")),
+		cli.Tree(NewCatalogCommand("catalog")),
+		cli.Tree(cmdManifest,
+			cli.Tree(NewManifestInspectCommand("inspect")),
+		),
+		cli.Tree(NewMutateCommand("mutate")),
+		cli.Tree(NewImagePullCommand("pull")),
+		cli.Tree(NewImagePushCommand("push")),
+		cli.Tree(NewRebaseCommand("rebase")),
+		cli.Tree(NewImageRemoveCommand("rmi")),
+		cli.Tree(NewImageSaveCommand("save")),
+		cli.Tree(NewSquashCommand("squash")),
+		cli.Tree(NewImageTagCommand("tag")),
+	).Run(os.Args[1:]); err != nil {
+		os.Exit(src.WriteError(os.Stderr, err, errorFormat))
 	}
 }
@@ -7,6 +7,7 @@ require (
 	github.com/containerd/containerd v1.6.20
 	github.com/docker/distribution v2.8.2+incompatible
 	github.com/docker/docker v24.0.2+incompatible
+	github.com/docker/docker-credential-helpers v0.7.0
 	github.com/docker/go-units v0.5.0
 	github.com/dustin/go-humanize v1.0.0
 	github.com/google/go-containerregistry v0.15.2
@@ -19,6 +20,8 @@ require (
 	github.com/sirupsen/logrus v1.9.0
 	github.com/stretchr/testify v1.8.1
 	github.com/tinylib/msgp v1.1.1
+	golang.org/x/crypto v0.2.0
+	golang.org/x/mod v0.10.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -29,7 +32,6 @@ require (
 	github.com/containerd/typeurl v1.0.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/cli v23.0.5+incompatible // indirect
-	github.com/docker/docker-credential-helpers v0.7.0 // indirect
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
@@ -0,0 +1,259 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/dustin/go-humanize"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+	"github.com/opencontainers/go-digest"
+)
+
+type AnalyzeReport struct {
+	Image  string         `json:"image"`
+	Layers []AnalyzeLayer `json:"layers"`
+	// Total size of files in all layers
+	TotalSize int64 `json:"totalSize"`
+	// Size of files in merged image filesystem
+	ImageSize   int64              `json:"imageSize"`
+	WastedSize  int64              `json:"wastedSize"`
+	Efficiency  float64            `json:"efficiency"`
+	Wasted      []AnalyzeWasted    `json:"wasted"`
+	Duplicates  []AnalyzeDuplicate `json:"duplicates"`
+	Directories []AnalyzeDirectory `json:"directories"`
+}
+
+type AnalyzeLayer struct {
+	Index  int           `json:"index"`
+	Digest digest.Digest `json:"digest"`
+	Files  int           `json:"files"`
+	Size   int64         `json:"size"`
+	// Size of layer files, which are overwritten or deleted by later layers
+	WastedSize int64 `json:"wastedSize"`
+}
+
+type AnalyzeWasted struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Layer, which contains file
+	Layer int `json:"layer"`
+	// Layer, which overwrites or deletes file
+	By      int  `json:"by"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+type AnalyzeDuplicate struct {
+	Path      string `json:"path"`
+	Layers    []int  `json:"layers"`
+	TotalSize int64  `json:"totalSize"`
+}
+
+type AnalyzeDirectory struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// CheckWasted returns ErrWastedLimit if wasted space exceeds limit. Limit is size (like 10MB) or percent of total size (like 5%).
+func (r *AnalyzeReport) CheckWasted(limit string) error {
+	var maxWasted int64
+	if percent, ok := strings.CutSuffix(limit, "%"); ok {
+		value, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return errorx.IllegalArgument.Wrap(err, "invalid wasted space limit: %s", limit)
+		}
+		maxWasted = int64(float64(r.TotalSize) * value / 100)
+	} else {
+		value, err := humanize.ParseBytes(limit)
+		if err != nil {
+			return errorx.IllegalArgument.Wrap(err, "invalid wasted space limit: %s", limit)
+		}
+		maxWasted = int64(value)
+	}
+	if r.WastedSize > maxWasted {
+		return ErrWastedLimit.New("wasted space %s exceeds limit %s", humanize.Bytes(uint64(r.WastedSize)), limit)
+	}
+	return nil
+}
+
+// Analyze reports space wasted by files, which are overwritten or deleted by later image layers.
+// Lists in report are sorted by size and limited by top items (unlimited if top <= 0).
+func (s *State) Analyze(ctx context.Context, image name.Reference, top int) (*AnalyzeReport, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+
+	report := &AnalyzeReport{
+		Image:  image.Name(),
+		Layers: make([]AnalyzeLayer, len(manifest.Layers)),
+	}
+	root := s.EmptyLayer()
+	origin := make(map[*tar.Header]int)
+	occurrences := make(map[string][]int)
+	sizes := make(map[string]int64)
+	for index, layer := range manifest.Layers {
+		fsdiff, err := s.LayerTree(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		layerInfo := &report.Layers[index]
+		layerInfo.Index = index
+		layerInfo.Digest = layer.Digest
+		fsdiff.Walk(func(node *TreeNode) {
+			origin[node.Header] = index
+			if node.Typeflag != tar.TypeReg {
+				return
+			}
+			layerInfo.Files++
+			layerInfo.Size += node.Size
+			occurrences[node.Name] = append(occurrences[node.Name], index)
+			sizes[node.Name] += node.Size
+		})
+		report.TotalSize += layerInfo.Size
+
+		analyzeDiff(root, fsdiff, func(node *TreeNode, deleted bool) {
+			report.Wasted = append(report.Wasted, AnalyzeWasted{
+				Path:    "/" + node.Name,
+				Size:    node.Size,
+				Layer:   origin[node.Header],
+				By:      index,
+				Deleted: deleted,
+			})
+		})
+		root.ApplyDiff(fsdiff)
+	}
+
+	for _, wasted := range report.Wasted {
+		report.WastedSize += wasted.Size
+		report.Layers[wasted.Layer].WastedSize += wasted.Size
+	}
+	report.ImageSize = report.TotalSize - report.WastedSize
+	report.Efficiency = 1
+	if report.TotalSize > 0 {
+		report.Efficiency = float64(report.ImageSize) / float64(report.TotalSize)
+	}
+
+	for entryName, layers := range occurrences {
+		if len(layers) > 1 {
+			report.Duplicates = append(report.Duplicates, AnalyzeDuplicate{
+				Path:      "/" + entryName,
+				Layers:    layers,
+				TotalSize: sizes[entryName],
+			})
+		}
+	}
+	report.Directories = analyzeDirectories(root)
+
+	sort.Slice(report.Wasted, func(i, j int) bool {
+		a, b := report.Wasted[i], report.Wasted[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Layer < b.Layer
+	})
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		a, b := report.Duplicates[i], report.Duplicates[j]
+		if a.TotalSize != b.TotalSize {
+			return a.TotalSize > b.TotalSize
+		}
+		return a.Path < b.Path
+	})
+	if top > 0 {
+		if len(report.Wasted) > top {
+			report.Wasted = report.Wasted[:top]
+		}
+		if len(report.Duplicates) > top {
+			report.Duplicates = report.Duplicates[:top]
+		}
+		if len(report.Directories) > top {
+			report.Directories = report.Directories[:top]
+		}
+	}
+	return report, nil
+}
+
+// analyzeDiff calls wasted for every regular file of merged tree, which is overwritten or deleted by diff.
+func analyzeDiff(merged *TreeNode, diff *TreeNode, wasted func(node *TreeNode, deleted bool)) {
+	removed := func(node *TreeNode, deleted bool) {
+		node.Walk(func(child *TreeNode) {
+			if child.Typeflag == tar.TypeReg {
+				wasted(child, deleted)
+			}
+		})
+	}
+	if merged.Typeflag != tar.TypeDir || diff.Typeflag != tar.TypeDir {
+		removed(merged, false)
+		return
+	}
+	if _, ok := diff.Child[archive.WhiteoutOpaqueDir]; ok {
+		for _, child := range merged.Child {
+			removed(child, true)
+		}
+		return
+	}
+	for childName, child := range diff.Child {
+		if strings.HasPrefix(childName, archive.WhiteoutMetaPrefix) {
+			continue
+		}
+		if strings.HasPrefix(childName, archive.WhiteoutPrefix) {
+			if old := merged.Child[childName[len(archive.WhiteoutPrefix):]]; old != nil {
+				removed(old, true)
+			}
+			continue
+		}
+		if old := merged.Child[childName]; old != nil {
+			analyzeDiff(old, child, wasted)
+		}
+	}
+}
+
+// analyzeDirectories returns directories of merged filesystem sorted by total size of files.
+func analyzeDirectories(root *TreeNode) []AnalyzeDirectory {
+	var directories []AnalyzeDirectory
+	var collect func(node *TreeNode) (int, int64)
+	collect = func(node *TreeNode) (int, int64) {
+		if node.Typeflag != tar.TypeDir {
+			if node.Typeflag == tar.TypeReg {
+				return 1, node.Size
+			}
+			return 0, 0
+		}
+		var files int
+		var size int64
+		for _, child := range node.Child {
+			childFiles, childSize := collect(child)
+			files += childFiles
+			size += childSize
+		}
+		if node != root {
+			directories = append(directories, AnalyzeDirectory{
+				Path:  path.Join("/", node.Name),
+				Files: files,
+				Size:  size,
+			})
+		}
+		return files, size
+	}
+	collect(root)
+	sort.Slice(directories, func(i, j int) bool {
+		a, b := directories[i], directories[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Path < b.Path
+	})
+	return directories
+}
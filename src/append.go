@@ -0,0 +1,130 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/blang/vfs"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/uuid"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/klauspost/compress/gzip"
+	"github.com/opencontainers/go-digest"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type AppendDir struct {
+	// Host directory or file
+	Source string
+	// Absolute path in image
+	Dest string
+}
+
+// Append adds tarballs as new layers on top of base image and saves result as target image.
+// Directories are added after tarballs as single layer.
+func (s *State) Append(ctx context.Context, base string, tarballs []string, dirs []AppendDir, target name.Reference) (*schema2.DeserializedManifest, error) {
+	buildContext, err := NewBuildContext(ctx, s, base, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, tarball := range tarballs {
+		diffID, layer, err := s.importLayer(ctx, tarball)
+		if err != nil {
+			return nil, err
+		}
+		if err := buildContext.AppendLayer(ctx, diffID, *layer, fmt.Sprintf("porter append --layer %s", path.Base(tarball))); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range dirs {
+		dest := dir.Dest
+		if stat, err := os.Stat(dir.Source); err != nil {
+			return nil, err
+		} else if stat.IsDir() && !strings.HasSuffix(dest, "/") {
+			// Directory content is copied into destination directory
+			dest += "/"
+		}
+		if err := buildContext.AddFiles(fmt.Sprintf("porter append --dir %s:%s", dir.Source, dir.Dest), []string{dir.Source}, dest); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := buildContext.BuildManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveManifest(ctx, manifest, target); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// importLayer stores tarball as layer blob. Gzipped tarballs are stored as is, other ones are compressed.
+func (s *State) importLayer(ctx context.Context, tarball string) (digest.Digest, *distribution.Descriptor, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return s.writeCompressedBlob(ctx, func(w io.Writer) error {
+			_, err := io.Copy(w, r)
+			return err
+		})
+	}
+
+	tempFile := "~" + uuid.Generate().String() + ".tar.gz"
+	hashTr := sha256.New()
+	hashGz := sha256.New()
+
+	fs := s.stateVfs
+	defer fs.Remove(tempFile)
+
+	temp, err := vfs.Create(fs, tempFile)
+	if err != nil {
+		return "", nil, err
+	}
+	defer temp.Close()
+
+	tee := io.TeeReader(r, io.MultiWriter(temp, hashGz))
+	gz, err := gzip.NewReader(tee)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.Copy(hashTr, gz); err != nil {
+		return "", nil, err
+	}
+	// Keep trailing data for same blob digest
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return "", nil, err
+	}
+	size, err := temp.Seek(0, 1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	desc := distribution.Descriptor{
+		MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+		Size:      size,
+		Digest:    digest.NewDigestFromBytes(digest.SHA256, hashGz.Sum(nil)),
+	}
+	target := s.blobName(desc, "")
+	if err := storeBlob(fs, tempFile, target); err != nil {
+		return "", nil, err
+	}
+	return digest.NewDigestFromBytes(digest.SHA256, hashTr.Sum(nil)), &desc, nil
+}
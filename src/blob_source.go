@@ -0,0 +1,28 @@
+package src
+
+import (
+	"context"
+
+	"github.com/docker/distribution"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+var bucketSource = "source.v1"
+
+// SaveBlobSource remembers repository from which blob was downloaded.
+func (s *State) SaveBlobSource(ctx context.Context, blob distribution.Descriptor, repo name.Repository) error {
+	return s.cacheSave(bucketSource, blob.Digest.String(), []byte(repo.Name()))
+}
+
+// LoadBlobSource returns repository from which blob was downloaded or nil if it is unknown.
+func (s *State) LoadBlobSource(ctx context.Context, blob distribution.Descriptor) (*name.Repository, error) {
+	cached, found, err := s.cacheLoad(bucketSource, blob.Digest.String())
+	if err != nil || !found {
+		return nil, err
+	}
+	repo, err := name.NewRepository(string(cached))
+	if err != nil {
+		return nil, nil
+	}
+	return &repo, nil
+}
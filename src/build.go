@@ -8,10 +8,10 @@ import (
 	"path"
 
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/joomcode/errorx"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
 )
 
 type BuildArgs interface {
@@ -19,8 +19,18 @@ type BuildArgs interface {
 	GetTarget() string
 	GetTag() string
 	GetPlatform() string
+	GetSquash() bool
+	// Resolve base image to manifest digest
+	GetPin() bool
+	// Refresh cached base image manifest
+	GetPull() bool
 }
 
+const (
+	LabelBaseName   = "org.opencontainers.image.base.name"
+	LabelBaseDigest = "org.opencontainers.image.base.digest"
+)
+
 func (s *State) Build(ctx context.Context, args BuildArgs, contextPath string) (digest.Digest, error) {
 	var platform *specs.Platform
 	if args.GetPlatform() != "" {
@@ -46,10 +56,40 @@ func (s *State) Build(ctx context.Context, args BuildArgs, contextPath string) (
 		return "", err
 	}
 
-	buildContext, err := NewBuildContext(ctx, s, stage.BaseName, contextPath, platform)
+	baseName := stage.BaseName
+	if args.GetPull() && baseName != "scratch" {
+		base, err := name.ParseReference(baseName)
+		if err != nil {
+			return "", err
+		}
+		if err := s.refreshBase(ctx, base); err != nil {
+			return "", err
+		}
+	}
+	var pinned *name.Digest
+	if args.GetPin() && baseName != "scratch" {
+		base, err := name.ParseReference(baseName)
+		if err != nil {
+			return "", err
+		}
+		// Cached manifest is used until TTL expiry, base is already refreshed with --pull
+		resolved, err := s.Resolve(ctx, base, true)
+		if err != nil {
+			return "", err
+		}
+		logrus.Infof("pinned base image %s to %s", baseName, resolved.DigestStr())
+		baseName = resolved.Name()
+		pinned = &resolved
+	}
+
+	buildContext, err := NewBuildContext(ctx, s, baseName, contextPath, platform)
 	if err != nil {
 		return "", err
 	}
+	if pinned != nil {
+		buildContext.setLabel(LabelBaseName, pinned.Name())
+		buildContext.setLabel(LabelBaseDigest, pinned.DigestStr())
+	}
 
 	for _, command := range stage.Commands {
 		if err := buildContext.ApplyCommand(command); err != nil {
@@ -66,6 +106,16 @@ func (s *State) Build(ctx context.Context, args BuildArgs, contextPath string) (
 		if err != nil {
 			return "", err
 		}
+		// All instructions are applied to single delta layer, so only layers appended on top of it are merged
+		if args.GetSquash() {
+			if len(manifest.Layers)-buildContext.baseLayers > 1 {
+				if manifest, err = s.squashManifest(ctx, manifest, buildContext.baseLayers); err != nil {
+					return "", err
+				}
+			} else {
+				logrus.Infof("nothing to squash: built image has single new layer")
+			}
+		}
 		if err := s.SaveManifest(ctx, manifest, image); err != nil {
 			return "", err
 		}
@@ -73,6 +123,22 @@ func (s *State) Build(ctx context.Context, args BuildArgs, contextPath string) (
 	return "", nil
 }
 
+// refreshBase fetches base image manifest from registry and reports if cached base image was changed.
+func (s *State) refreshBase(ctx context.Context, base name.Reference) error {
+	previous, err := s.LoadManifest(ctx, base)
+	if err != nil {
+		return err
+	}
+	manifest, err := s.PullManifest(ctx, base, false)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return nil
+	}
+	return warnManifestUpdate(base, previous, manifest)
+}
+
 func (s *State) extractStage(stages []*instructions.Stage, name string) (*instructions.Stage, error) {
 	var result *instructions.Stage
 	for {
@@ -99,7 +165,7 @@ func (s *State) extractStage(stages []*instructions.Stage, name string) (*instru
 		}
 	}
 	if result == nil {
-		return nil, errorx.InternalError.New("can't find state with name: %s", name)
+		return nil, ErrDockerfileParse.New("can't find stage with name: %s", name)
 	}
 	return result, nil
 }
@@ -135,20 +201,20 @@ func (s *State) parseDockerFile(dockerFile string) ([]*instructions.Stage, error
 
 	parsed, err := parser.Parse(file)
 	if err != nil {
-		return nil, err
+		return nil, ErrDockerfileParse.Wrap(err, "can't parse Dockerfile: %s", dockerFile)
 	}
 	var stages []*instructions.Stage
 	for _, child := range parsed.AST.Children {
 		instruction, err := instructions.ParseInstruction(child)
 		if err != nil {
-			return nil, err
+			return nil, ErrDockerfileParse.Wrap(err, "%s:%d", dockerFile, child.StartLine)
 		}
 		if stage, ok := instruction.(*instructions.Stage); ok {
 			stages = append(stages, stage)
 			continue
 		}
 		if len(stages) == 0 {
-			return nil, errorx.IllegalFormat.New("FROM must be first directive in Dockerfile")
+			return nil, ErrDockerfileParse.New("FROM must be first directive in Dockerfile")
 		}
 		if command, ok := instruction.(instructions.Command); ok {
 			stage := stages[len(stages)-1]
@@ -160,7 +226,7 @@ func (s *State) parseDockerFile(dockerFile string) ([]*instructions.Stage, error
 				return err
 			}
 		}*/
-		return nil, errorx.InternalError.New("unexpected instruction: %s", child.Original)
+		return nil, ErrUnsupportedInstruction.New("unexpected instruction: %s", child.Original)
 	}
 	return stages, nil
 }
@@ -38,8 +38,12 @@ type BuildContext struct {
 	fs          FS
 	contextPath string
 	layers      []distribution.Descriptor
-	configFile  v1.ConfigFile
-	platform    *specs.Platform
+	// Number of base image layers
+	baseLayers int
+	// History entry of last instruction, which changed delta layer
+	deltaHistory int
+	configFile   v1.ConfigFile
+	platform     *specs.Platform
 }
 
 type FileFilter func(header *tar.Header)
@@ -75,13 +79,9 @@ func NewBuildContext(ctx context.Context, state *State, baseName string, context
 		return nil, err
 	}
 
-	root := state.EmptyLayer()
-	for _, layer := range baseManifest.Layers {
-		fsdiff, err := state.LayerTree(ctx, layer)
-		if err != nil {
-			return nil, err
-		}
-		root.ApplyDiff(fsdiff)
+	root, _, err := state.mergeLayerTrees(ctx, baseManifest.Layers)
+	if err != nil {
+		return nil, err
 	}
 
 	return &BuildContext{
@@ -91,6 +91,7 @@ func NewBuildContext(ctx context.Context, state *State, baseName string, context
 			Base: root,
 		},
 		layers:     baseManifest.Layers,
+		baseLayers: len(baseManifest.Layers),
 		configFile: imageManifest,
 		platform:   platform,
 	}, nil
@@ -112,15 +113,16 @@ func (b *BuildContext) BuildManifest(ctx context.Context) (*schema2.Deserialized
 	})
 }
 
+func (b *BuildContext) setLabel(key string, value string) {
+	if b.configFile.Config.Labels == nil {
+		b.configFile.Config.Labels = make(map[string]string)
+	}
+	b.configFile.Config.Labels[key] = value
+}
+
 func (b *BuildContext) ApplyCommand(cmd instructions.Command) error {
 	logrus.Infof("Apply command: %s", cmd)
-	b.configFile.History = append(b.configFile.History, v1.History{
-		Created: v1.Time{
-			Time: time.Now().UTC(),
-		},
-		CreatedBy:  fmt.Sprintf("%s", cmd.Name()),
-		EmptyLayer: true,
-	})
+	b.addHistory(fmt.Sprintf("%s", cmd), true)
 	switch cmd := cmd.(type) {
 	case *instructions.CopyCommand:
 		return b.applyCopyCommand(cmd)
@@ -137,11 +139,8 @@ func (b *BuildContext) ApplyCommand(cmd instructions.Command) error {
 			Retries:     cmd.Health.Retries,
 		}
 	case *instructions.LabelCommand:
-		if b.configFile.Config.Labels == nil {
-			b.configFile.Config.Labels = make(map[string]string)
-		}
 		for _, pair := range cmd.Labels {
-			b.configFile.Config.Labels[pair.Key] = pair.Value
+			b.setLabel(pair.Key, pair.Value)
 		}
 	case *instructions.WorkdirCommand:
 		b.configFile.Config.WorkingDir = cmd.Path
@@ -168,14 +167,56 @@ func (b *BuildContext) FlushDelta(ctx context.Context) error {
 	}
 	b.configFile.RootFS.DiffIDs = append(b.configFile.RootFS.DiffIDs, hash)
 	b.layers = append(b.layers, *layer)
+	// Next layers see flushed files in base
+	b.fs.Base.ApplyDiff(b.fs.Delta)
 	b.fs.Delta = nil
 	logrus.Infof("layer flushed: %s, %s, %v", layer.Digest, units.HumanSize(float64(layer.Size)), time.Now().Sub(t))
 
-	history := b.configFile.History
-	history[len(history)-1].EmptyLayer = false
+	b.configFile.History[b.deltaHistory].EmptyLayer = false
 	return nil
 }
 
+// AppendLayer adds existing cached layer on top of image.
+func (b *BuildContext) AppendLayer(ctx context.Context, diffID digest.Digest, layer distribution.Descriptor, createdBy string) error {
+	if err := b.FlushDelta(ctx); err != nil {
+		return err
+	}
+	fsdiff, err := b.state.LayerTree(ctx, layer)
+	if err != nil {
+		return err
+	}
+	hash, err := v1.NewHash(diffID.String())
+	if err != nil {
+		return err
+	}
+	b.fs.Base.ApplyDiff(fsdiff)
+	b.configFile.RootFS.DiffIDs = append(b.configFile.RootFS.DiffIDs, hash)
+	b.layers = append(b.layers, layer)
+	b.addHistory(createdBy, false)
+	return nil
+}
+
+// AddFiles copies host files or directories to image like COPY command.
+func (b *BuildContext) AddFiles(createdBy string, sources []string, dest string) error {
+	b.addHistory(createdBy, true)
+	return b.applyCopyCommand(&instructions.CopyCommand{
+		SourcesAndDest: instructions.SourcesAndDest{
+			SourcePaths: sources,
+			DestPath:    dest,
+		},
+	})
+}
+
+func (b *BuildContext) addHistory(createdBy string, emptyLayer bool) {
+	b.configFile.History = append(b.configFile.History, v1.History{
+		Created: v1.Time{
+			Time: time.Now().UTC(),
+		},
+		CreatedBy:  createdBy,
+		EmptyLayer: emptyLayer,
+	})
+}
+
 func (b *BuildContext) fileSHA256(ctx context.Context, file string) (digest.Digest, error) {
 	hash := sha256.New()
 	f, err := os.Open(file)
@@ -190,11 +231,29 @@ func (b *BuildContext) fileSHA256(ctx context.Context, file string) (digest.Dige
 }
 
 func (b *BuildContext) writeDeltaLayer(ctx context.Context) (digest.Digest, *distribution.Descriptor, error) {
+	return b.state.writeLayerBlob(ctx, func(t *tar.Writer) error {
+		return b.writeDir(t, b.fs.Delta)
+	})
+}
+
+// writeLayerBlob writes gzipped layer blob to cache and returns diff ID and layer descriptor.
+func (s *State) writeLayerBlob(ctx context.Context, write func(t *tar.Writer) error) (digest.Digest, *distribution.Descriptor, error) {
+	return s.writeCompressedBlob(ctx, func(w io.Writer) error {
+		t := tar.NewWriter(w)
+		if err := write(t); err != nil {
+			return err
+		}
+		return t.Close()
+	})
+}
+
+// writeCompressedBlob compresses uncompressed layer stream to cache and returns diff ID and layer descriptor.
+func (s *State) writeCompressedBlob(ctx context.Context, write func(w io.Writer) error) (digest.Digest, *distribution.Descriptor, error) {
 	tempFile := path.Join("~" + uuid.Generate().String() + ".tar.gz")
 	hashTr := sha256.New()
 	hashGz := sha256.New()
 
-	fs := b.state.stateVfs
+	fs := s.stateVfs
 	defer fs.Remove(tempFile)
 
 	f, err := vfs.Create(fs, tempFile)
@@ -208,11 +267,7 @@ func (b *BuildContext) writeDeltaLayer(ctx context.Context) (digest.Digest, *dis
 		return "", nil, err
 	}
 
-	t := tar.NewWriter(io.MultiWriter(gz, hashTr))
-	if err := b.writeDir(t, b.fs.Delta); err != nil {
-		return "", nil, err
-	}
-	if err := t.Close(); err != nil {
+	if err := write(io.MultiWriter(gz, hashTr)); err != nil {
 		return "", nil, err
 	}
 	if err := gz.Close(); err != nil {
@@ -228,9 +283,8 @@ func (b *BuildContext) writeDeltaLayer(ctx context.Context) (digest.Digest, *dis
 		Size:      size,
 		Digest:    digest.NewDigestFromBytes(digest.SHA256, hashGz.Sum(nil)),
 	}
-	target := b.state.blobName(desc, "")
-	_ = vfs.MkdirAll(fs, path.Dir(target), 0755)
-	if err := fs.Rename(tempFile, target); err != nil {
+	target := s.blobName(desc, "")
+	if err := storeBlob(fs, tempFile, target); err != nil {
 		return "", nil, err
 	}
 
@@ -274,7 +328,12 @@ func (b *BuildContext) writeDir(t *tar.Writer, dir *TreeNode) error {
 }
 
 func (b *BuildContext) SaveImageManifest(ctx context.Context) (*distribution.Descriptor, error) {
-	data, err := json.Marshal(b.configFile)
+	return b.state.saveConfigFile(ctx, &b.configFile, b.platform)
+}
+
+// saveConfigFile writes image configuration blob to cache.
+func (s *State) saveConfigFile(ctx context.Context, configFile *v1.ConfigFile, platform *specs.Platform) (*distribution.Descriptor, error) {
+	data, err := json.Marshal(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -283,11 +342,11 @@ func (b *BuildContext) SaveImageManifest(ctx context.Context) (*distribution.Des
 		MediaType: "application/vnd.docker.container.image.v1+json",
 		Size:      int64(len(data)),
 		Digest:    digest.NewDigestFromBytes(digest.SHA256, sum256[:]),
-		Platform:  b.platform,
+		Platform:  platform,
 	}
-	filename := b.state.blobName(descriptor, "")
+	filename := s.blobName(descriptor, "")
 
-	if err := safeWrite(b.state.stateVfs, filename, func(w io.Writer) error {
+	if err := safeWrite(s.stateVfs, filename, func(w io.Writer) error {
 		_, err := w.Write(data)
 		return err
 	}); err != nil {
@@ -329,8 +388,9 @@ func (b *BuildContext) applyEntrypointCommand(cmd *instructions.EntrypointComman
 func (b *BuildContext) applyCopyCommand(cmd *instructions.CopyCommand) error {
 	if cmd.From != "" {
 		// TODO: Not implemented copy from other docker image
-		return errorx.NotImplemented.New(cmd.String())
+		return ErrUnsupportedInstruction.New("copy from other image is not supported: %s", cmd.String())
 	}
+	b.deltaHistory = len(b.configFile.History) - 1
 	dest := cmd.DestPath
 	if !path.IsAbs(dest) {
 		dest = path.Join("/", b.configFile.Config.WorkingDir, dest)
@@ -2,17 +2,51 @@ package src
 
 import (
 	"io"
+	"path"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	"gopkg.in/yaml.v2"
 )
 
-const DefaultMinTemporaryAge = 5 * time.Minute
+const (
+	DefaultMinTemporaryAge = 5 * time.Minute
+	DefaultMaxRetries      = 3
+	DefaultTimeout         = time.Minute
+)
 
 type Config struct {
-	Auths           map[string]authn.AuthConfig `json:"auths"`
-	MinTemporaryAge time.Duration               `json:"minTemporaryAge"`
+	Auths map[string]authn.AuthConfig `json:"auths"`
+	// Name of docker-credential-* helper for credentials storage, separate credentials file by default
+	CredentialsStore string                    `json:"credentialsStore"`
+	MinTemporaryAge  time.Duration             `json:"minTemporaryAge"`
+	Registries       map[string]RegistryConfig `json:"registries"`
+	// Use only cached images and blobs, registry access fails
+	Offline bool `json:"offline"`
+	// Default lifetime of cached manifests, cached manifests never expire if zero
+	ManifestTTL time.Duration `json:"manifestTTL"`
+	// Retries of failed registry requests, negative to disable retries
+	MaxRetries int `json:"maxRetries"`
+	// Registry response timeout for single request, negative to disable timeout
+	Timeout time.Duration `json:"timeout"`
+}
+
+type RegistryConfig struct {
+	// Pull-through mirrors (registry host with optional repository prefix), tried before upstream registry
+	Mirrors []string `json:"mirrors"`
+	// Repository rewrite rules like `library/*: mirror.corp/dockerhub/*`
+	Rewrite map[string]string `json:"rewrite"`
+	// Use plain HTTP instead of HTTPS
+	HTTP bool `json:"http"`
+	// Skip TLS certificate verification
+	Insecure bool `json:"insecure"`
+	// Path to custom CA bundle in PEM format
+	CA string `json:"ca"`
+	// Lifetime of cached manifests, overrides global value if not zero
+	ManifestTTL time.Duration `json:"manifestTTL"`
+	// Lifetime of cached manifests for repository patterns like `library/*`, the most specific pattern wins
+	RepositoryTTL map[string]time.Duration `json:"repositoryTTL"`
 }
 
 func (c *Config) Load(reader io.Reader) error {
@@ -27,3 +61,57 @@ func (c *Config) GetMinTemporaryAge() time.Duration {
 	}
 	return c.MinTemporaryAge
 }
+
+func (c *Config) GetMaxRetries() int {
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	if c.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return c.MaxRetries
+}
+
+func (c *Config) GetTimeout() time.Duration {
+	if c.Timeout < 0 {
+		return 0
+	}
+	if c.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+// GetManifestTTL returns lifetime of cached manifests of repository, zero means that manifests never expire.
+func (c *Config) GetManifestTTL(repo name.Repository) time.Duration {
+	config := c.GetRegistry(repo.RegistryStr())
+	var matched string
+	for pattern := range config.RepositoryTTL {
+		if ok, _ := path.Match(pattern, repo.RepositoryStr()); !ok {
+			continue
+		}
+		if len(pattern) > len(matched) || (len(pattern) == len(matched) && pattern < matched) {
+			matched = pattern
+		}
+	}
+	if ttl, ok := config.RepositoryTTL[matched]; ok {
+		return ttl
+	}
+	if config.ManifestTTL != 0 {
+		return config.ManifestTTL
+	}
+	return c.ManifestTTL
+}
+
+// GetRegistry returns configuration for registry host. Registry names like `docker.io` are normalized.
+func (c *Config) GetRegistry(registry string) RegistryConfig {
+	if config, ok := c.Registries[registry]; ok {
+		return config
+	}
+	for key, config := range c.Registries {
+		if normalized, err := name.NewRegistry(key); err == nil && normalized.RegistryStr() == registry {
+			return config
+		}
+	}
+	return RegistryConfig{}
+}
@@ -0,0 +1,128 @@
+package src
+
+import (
+	"context"
+	"os"
+
+	"github.com/docker/distribution"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+type copyImage struct {
+	v1.Image
+	ctx    context.Context
+	state  *State
+	source name.Repository
+}
+
+// Copy copies image between registries without storing it in local cache.
+// Blobs, which is already cached, are uploaded from cache, other blobs are streamed from source registry.
+func (s *State) Copy(ctx context.Context, source string, target string, allPlatforms bool) (v1.Hash, error) {
+	sourceInfo, err := name.ParseReference(source)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	targetInfo, err := name.ParseReference(target)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	var desc *remote.Descriptor
+	if err := s.withMirrors(sourceInfo, func(ref name.Reference) error {
+		desc, err = remote.Get(ref, append(s.RemoveOptions(ref), remote.WithContext(ctx))...)
+		if err == nil {
+			sourceInfo = ref
+		}
+		return err
+	}); err != nil {
+		return v1.Hash{}, err
+	}
+	targetInfo = s.RemoteReference(targetInfo)
+
+	if allPlatforms && desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		if err := remote.WriteIndex(targetInfo, index, append(s.RemoveOptions(targetInfo), remote.WithContext(ctx))...); err != nil {
+			return v1.Hash{}, remoteError(err, targetInfo.Name())
+		}
+		return index.Digest()
+	}
+
+	image, err := desc.Image()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if desc.MediaType.IsIndex() {
+		configFile, err := image.ConfigFile()
+		if err != nil {
+			return v1.Hash{}, remoteError(err, sourceInfo.Name())
+		}
+		logrus.Warnf("copy only %s image of manifest list %s, use --all-platforms to copy manifest list", configFile.Platform(), sourceInfo.Name())
+	}
+	if err := remote.Write(targetInfo, &copyImage{
+		Image:  image,
+		ctx:    ctx,
+		state:  s,
+		source: sourceInfo.Context(),
+	}, append(s.RemoveOptions(targetInfo), remote.WithContext(ctx))...); err != nil {
+		return v1.Hash{}, remoteError(err, targetInfo.Name())
+	}
+	return image.Digest()
+}
+
+func (c copyImage) Layers() ([]v1.Layer, error) {
+	layers, err := c.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]v1.Layer, 0, len(layers))
+	for _, layer := range layers {
+		cached, err := c.cachedLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			result = append(result, cached)
+			continue
+		}
+		result = append(result, layer)
+	}
+	return result, nil
+}
+
+// cachedLayer returns layer from local cache or nil if layer blob is not downloaded.
+func (c copyImage) cachedLayer(layer v1.Layer) (v1.Layer, error) {
+	hash, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	descriptor := distribution.Descriptor{
+		MediaType: string(mediaType),
+		Size:      size,
+		Digest:    digest.Digest(hash.String()),
+	}
+	if _, err := c.state.stateVfs.Stat(c.state.blobName(descriptor, "")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &remote.MountableLayer{
+		Layer:     c.state.NewLayer(c.ctx, descriptor),
+		Reference: c.source.Digest(hash.String()),
+	}, nil
+}
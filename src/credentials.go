@@ -0,0 +1,277 @@
+package src
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// CredentialsKeyEnv is environment variable with key for credentials file encryption.
+const CredentialsKeyEnv = "PORTER_CREDENTIALS_KEY"
+
+const (
+	// Encrypted file format: prefix, base64 scrypt salt, colon, base64 nonce with AES-GCM sealed data
+	encryptedPrefix = "porter-scrypt-aes-gcm:"
+	tokenUsername   = "<token>"
+)
+
+// Key derivation parameters
+const (
+	scryptSaltSize = 16
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	aesKeySize     = 32
+)
+
+// CredentialStore keeps registry credentials outside porter configuration file.
+type CredentialStore interface {
+	// Get returns credentials for registry or nil if credentials not found
+	Get(registry string) (*authn.AuthConfig, error)
+	Store(registry string, auth authn.AuthConfig) error
+	Erase(registry string) error
+}
+
+// fileCredentialStore keeps credentials in separate YAML file, optionally encrypted.
+type fileCredentialStore struct {
+	filename string
+	key      string
+	mutex    sync.Mutex
+	// Loaded credentials, file is read and decrypted (which is slow by design) only once
+	cached map[string]authn.AuthConfig
+}
+
+// helperCredentialStore delegates credentials storage to docker-credential-* helper.
+type helperCredentialStore struct {
+	program client.ProgramFunc
+}
+
+func newCredentialStore(config Config, configFile string) CredentialStore {
+	if config.CredentialsStore != "" {
+		return &helperCredentialStore{
+			program: client.NewShellProgramFunc("docker-credential-" + config.CredentialsStore),
+		}
+	}
+	filename := ""
+	if configFile != "" {
+		filename = strings.TrimSuffix(configFile, path.Ext(configFile)) + ".credentials" + path.Ext(configFile)
+	}
+	return &fileCredentialStore{
+		filename: filename,
+		key:      os.Getenv(CredentialsKeyEnv),
+	}
+}
+
+func (f *fileCredentialStore) Get(registry string) (*authn.AuthConfig, error) {
+	auths, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	if auth, ok := auths[registry]; ok {
+		return &auth, nil
+	}
+	return nil, nil
+}
+
+func (f *fileCredentialStore) Store(registry string, auth authn.AuthConfig) error {
+	auths, err := f.load()
+	if err != nil {
+		return err
+	}
+	auths[registry] = auth
+	return f.save(auths)
+}
+
+func (f *fileCredentialStore) Erase(registry string) error {
+	auths, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := auths[registry]; !ok {
+		return nil
+	}
+	delete(auths, registry)
+	return f.save(auths)
+}
+
+// load returns copy of stored credentials, which can be modified by caller.
+func (f *fileCredentialStore) load() (map[string]authn.AuthConfig, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.cached == nil {
+		auths, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+		f.cached = auths
+	}
+	auths := make(map[string]authn.AuthConfig, len(f.cached))
+	for registry, auth := range f.cached {
+		auths[registry] = auth
+	}
+	return auths, nil
+}
+
+func (f *fileCredentialStore) read() (map[string]authn.AuthConfig, error) {
+	auths := make(map[string]authn.AuthConfig)
+	if f.filename == "" {
+		return auths, nil
+	}
+	data, err := os.ReadFile(f.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return auths, nil
+		}
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(encryptedPrefix)) {
+		if data, err = f.decrypt(data[len(encryptedPrefix):]); err != nil {
+			return nil, err
+		}
+	}
+	if err := yaml.Unmarshal(data, &auths); err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "can't parse credentials file: %s", f.filename)
+	}
+	return auths, nil
+}
+
+func (f *fileCredentialStore) save(auths map[string]authn.AuthConfig) error {
+	if f.filename == "" {
+		return errorx.IllegalState.New("credentials file is not defined")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cached = nil
+	data, err := yaml.Marshal(auths)
+	if err != nil {
+		return err
+	}
+	if f.key != "" {
+		if data, err = f.encrypt(data); err != nil {
+			return err
+		}
+		data = append([]byte(encryptedPrefix), data...)
+	} else {
+		logrus.Warnf("credentials are stored unencrypted, set %s to encrypt: %s", CredentialsKeyEnv, f.filename)
+	}
+	_ = os.MkdirAll(path.Dir(f.filename), 0755)
+	return os.WriteFile(f.filename, data, 0600)
+}
+
+// aead derives AES key from credentials key with scrypt.
+func (f *fileCredentialStore) aead(salt []byte) (cipher.AEAD, error) {
+	if f.key == "" {
+		return nil, errorx.IllegalState.New("credentials file is encrypted, %s is not defined: %s", CredentialsKeyEnv, f.filename)
+	}
+	key, err := scrypt.Key([]byte(f.key), salt, scryptN, scryptR, scryptP, aesKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f *fileCredentialStore) encrypt(data []byte) ([]byte, error) {
+	salt := make([]byte, scryptSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := f.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	encrypted := gcm.Seal(nonce, nonce, data, nil)
+	return []byte(base64.StdEncoding.EncodeToString(salt) + ":" + base64.StdEncoding.EncodeToString(encrypted)), nil
+}
+
+func (f *fileCredentialStore) decrypt(data []byte) ([]byte, error) {
+	if f.key == "" {
+		return nil, errorx.IllegalState.New("credentials file is encrypted, %s is not defined: %s", CredentialsKeyEnv, f.filename)
+	}
+	encodedSalt, encodedData, ok := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !ok {
+		return nil, errorx.IllegalFormat.New("can't find salt in credentials file: %s", f.filename)
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "can't decode credentials file: %s", f.filename)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "can't decode credentials file: %s", f.filename)
+	}
+	gcm, err := f.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < gcm.NonceSize() {
+		return nil, errorx.IllegalFormat.New("credentials file is truncated: %s", f.filename)
+	}
+	nonce := encrypted[:gcm.NonceSize()]
+	decrypted, err := gcm.Open(nil, nonce, encrypted[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errorx.IllegalArgument.Wrap(err, "can't decrypt credentials file, check %s: %s", CredentialsKeyEnv, f.filename)
+	}
+	return decrypted, nil
+}
+
+func (h *helperCredentialStore) Get(registry string) (*authn.AuthConfig, error) {
+	creds, err := client.Get(h.program, registry)
+	if err != nil {
+		if credentials.IsErrCredentialsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if creds.Username == tokenUsername {
+		return &authn.AuthConfig{
+			IdentityToken: creds.Secret,
+		}, nil
+	}
+	return &authn.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+func (h *helperCredentialStore) Store(registry string, auth authn.AuthConfig) error {
+	creds := &credentials.Credentials{
+		ServerURL: registry,
+		Username:  auth.Username,
+		Secret:    auth.Password,
+	}
+	if auth.IdentityToken != "" {
+		creds.Username = tokenUsername
+		creds.Secret = auth.IdentityToken
+	}
+	return client.Store(h.program, creds)
+}
+
+func (h *helperCredentialStore) Erase(registry string) error {
+	if err := client.Erase(h.program, registry); err != nil && !credentials.IsErrCredentialsNotFound(err) {
+		return err
+	}
+	return nil
+}
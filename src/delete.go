@@ -0,0 +1,235 @@
+package src
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+)
+
+// Delete resolves image reference to manifest digest and removes manifest from registry by digest
+// (registries don't support tag deletion). Manifest removal deletes all its tags, so without byDigest
+// manifest referenced by other tags is kept and error is returned.
+func (s *State) Delete(ctx context.Context, image name.Reference, byDigest bool) (name.Digest, error) {
+	target := s.RemoteReference(image)
+	if err := s.checkOnline(target.Name()); err != nil {
+		return name.Digest{}, err
+	}
+	options := append(s.RemoveOptions(target), remote.WithContext(ctx))
+	desc, err := remote.Head(target, options...)
+	if err != nil {
+		return name.Digest{}, remoteError(err, target.Name())
+	}
+	resolved := target.Context().Digest(desc.Digest.String())
+	if !byDigest {
+		tags, err := s.tagDigests(ctx, target.Context(), options)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		var others []string
+		for tag, digest := range tags {
+			if digest == desc.Digest && tag != target.Identifier() {
+				others = append(others, tag)
+			}
+		}
+		if len(others) > 0 {
+			sort.Strings(others)
+			return name.Digest{}, errorx.IllegalState.New("manifest %s is also tagged as %s, delete by digest to remove all its tags", resolved.Name(), strings.Join(others, ", "))
+		}
+	}
+	if err := remote.Delete(resolved, options...); err != nil {
+		return name.Digest{}, remoteError(err, resolved.Name())
+	}
+	logrus.Infof("deleted %s (%s)", target.Name(), desc.Digest)
+	return resolved, nil
+}
+
+// tagDigests returns manifest digests of all repository tags in registry.
+func (s *State) tagDigests(ctx context.Context, repo name.Repository, options []remote.Option) (map[string]v1.Hash, error) {
+	tags, err := remote.List(repo, options...)
+	if err != nil {
+		return nil, remoteError(err, repo.Name())
+	}
+	result := make(map[string]v1.Hash, len(tags))
+	for _, tag := range tags {
+		desc, err := remote.Head(repo.Tag(tag), options...)
+		if err != nil {
+			return nil, remoteError(err, repo.Tag(tag).Name())
+		}
+		result[tag] = desc.Digest
+	}
+	return result, nil
+}
+
+type RetentionPolicy struct {
+	// Glob pattern of tags affected by policy, all tags for empty pattern
+	Pattern string
+	// Number of newest matching tags to keep
+	KeepLast int
+	// Only tags created earlier are deleted, zero to ignore tag age
+	OlderThan time.Duration
+}
+
+type RetentionItem struct {
+	Tag     string    `json:"tag"`
+	Digest  v1.Hash   `json:"digest"`
+	Created time.Time `json:"created"`
+	Delete  bool      `json:"delete"`
+}
+
+// Retention applies retention policy to repository tags. Tags are ordered by image creation time, newest first,
+// tags of images without creation time are kept.
+// Manifests are deleted by digest, so manifest referenced by any remaining tag is kept.
+// Nothing is removed from registry if dryRun is set.
+func (s *State) Retention(ctx context.Context, repo name.Repository, policy RetentionPolicy, dryRun bool) ([]RetentionItem, error) {
+	if policy.KeepLast <= 0 && policy.OlderThan <= 0 {
+		return nil, errorx.IllegalArgument.New("retention policy must limit tag count or age")
+	}
+	if policy.Pattern != "" {
+		if _, err := path.Match(policy.Pattern, ""); err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "invalid tag pattern: %s", policy.Pattern)
+		}
+	}
+	// Tags are resolved against registry, where manifests are deleted, mirrors can be stale
+	target := s.RemoteReference(repo.Tag("latest")).Context()
+	if err := s.checkOnline(target.Name()); err != nil {
+		return nil, err
+	}
+	options := append(s.RemoveOptions(target.Tag("latest")), remote.WithContext(ctx))
+	tags, err := remote.List(target, options...)
+	if err != nil {
+		return nil, remoteError(err, target.Name())
+	}
+
+	items := make([]RetentionItem, 0, len(tags))
+	// Tags without creation time are never deleted
+	var skipped []RetentionItem
+	// Manifests referenced by tags outside of policy
+	kept := make(map[v1.Hash]struct{})
+	for _, tag := range tags {
+		if policy.Pattern != "" {
+			if matched, _ := path.Match(policy.Pattern, tag); !matched {
+				desc, err := remote.Head(target.Tag(tag), options...)
+				if err != nil {
+					return nil, remoteError(err, target.Tag(tag).Name())
+				}
+				kept[desc.Digest] = struct{}{}
+				continue
+			}
+		}
+		digest, created, err := s.remoteCreated(target.Tag(tag), options)
+		if err != nil {
+			return nil, err
+		}
+		if created.IsZero() {
+			logrus.Warnf("skip %s: image has no creation time", tag)
+			kept[digest] = struct{}{}
+			skipped = append(skipped, RetentionItem{
+				Tag:    tag,
+				Digest: digest,
+			})
+			continue
+		}
+		items = append(items, RetentionItem{
+			Tag:     tag,
+			Digest:  digest,
+			Created: created,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Created.After(items[j].Created)
+	})
+
+	deadline := time.Now().Add(-policy.OlderThan)
+	for i := range items {
+		item := &items[i]
+		item.Delete = i >= policy.KeepLast && (policy.OlderThan <= 0 || item.Created.Before(deadline))
+		if !item.Delete {
+			kept[item.Digest] = struct{}{}
+		}
+	}
+	for i := range items {
+		item := &items[i]
+		if _, ok := kept[item.Digest]; ok && item.Delete {
+			logrus.Warnf("keep %s: manifest %s is referenced by kept tag", item.Tag, item.Digest)
+			item.Delete = false
+		}
+	}
+	items = append(items, skipped...)
+	if dryRun {
+		return items, nil
+	}
+
+	deleted := make(map[v1.Hash]struct{})
+	for _, item := range items {
+		if !item.Delete {
+			continue
+		}
+		// Manifest is removed with all its tags at once
+		if _, ok := deleted[item.Digest]; ok {
+			continue
+		}
+		deleted[item.Digest] = struct{}{}
+		if _, err := s.Delete(ctx, repo.Digest(item.Digest.String()), true); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// remoteCreated returns manifest digest and image creation time from registry. First platform image is used for manifest list.
+func (s *State) remoteCreated(image name.Reference, options []remote.Option) (v1.Hash, time.Time, error) {
+	desc, err := remote.Get(image, options...)
+	if err != nil {
+		return v1.Hash{}, time.Time{}, remoteError(err, image.Name())
+	}
+	configDesc := desc
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return v1.Hash{}, time.Time{}, err
+		}
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return v1.Hash{}, time.Time{}, err
+		}
+		if len(indexManifest.Manifests) == 0 {
+			return desc.Digest, time.Time{}, nil
+		}
+		child := image.Context().Digest(indexManifest.Manifests[0].Digest.String())
+		if configDesc, err = remote.Get(child, options...); err != nil {
+			return v1.Hash{}, time.Time{}, remoteError(err, child.Name())
+		}
+		if configDesc.MediaType.IsIndex() {
+			return desc.Digest, time.Time{}, nil
+		}
+	}
+	remoteImage, err := configDesc.Image()
+	if err != nil {
+		return v1.Hash{}, time.Time{}, err
+	}
+	configFile, err := remoteImage.ConfigFile()
+	if err != nil {
+		return v1.Hash{}, time.Time{}, remoteError(err, image.Name())
+	}
+	return desc.Digest, imageCreated(configFile), nil
+}
+
+// imageCreated returns image creation time. Built images inherit creation time of base image (or have none),
+// so time of newest history entry is used, when it is later.
+func imageCreated(configFile *v1.ConfigFile) time.Time {
+	created := configFile.Created.Time
+	for _, history := range configFile.History {
+		if history.Created.After(created) {
+			created = history.Created.Time
+		}
+	}
+	return created
+}
@@ -0,0 +1,282 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/opencontainers/go-digest"
+)
+
+type DiffKind string
+
+const (
+	DiffAdded    DiffKind = "added"
+	DiffRemoved  DiffKind = "removed"
+	DiffModified DiffKind = "modified"
+)
+
+type DiffEntry struct {
+	Path    string        `json:"path"`
+	Kind    DiffKind      `json:"kind"`
+	Changes []string      `json:"changes,omitempty"`
+	Old     *DiffFileInfo `json:"old,omitempty"`
+	New     *DiffFileInfo `json:"new,omitempty"`
+}
+
+type DiffFileInfo struct {
+	Type     string `json:"type"`
+	Mode     string `json:"mode"`
+	Uid      int    `json:"uid"`
+	Gid      int    `json:"gid"`
+	Size     int64  `json:"size"`
+	Linkname string `json:"linkname,omitempty"`
+	// Content hash, filled only when content comparison is needed
+	Digest digest.Digest `json:"digest,omitempty"`
+	Layer  digest.Digest `json:"layer,omitempty"`
+	layer  distribution.Descriptor
+}
+
+// Diff compares merged filesystems of two cached images.
+// Content of regular files with same size from different layers is compared only if compareContent is set.
+func (s *State) Diff(ctx context.Context, imageA name.Reference, imageB name.Reference, prefix string, compareContent bool) ([]DiffEntry, error) {
+	fsA, err := s.ImageFS(ctx, imageA)
+	if err != nil {
+		return nil, err
+	}
+	fsB, err := s.ImageFS(ctx, imageB)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	nodeA, nodeB := fsA.Base, fsB.Base
+	if prefix != "" {
+		nodeA, nodeB = fsA.Get(prefix), fsB.Get(prefix)
+	}
+
+	var entries []DiffEntry
+	// Candidates for content comparison
+	var pending []int
+	var walk func(entryPath string, a *TreeNode, b *TreeNode)
+	walk = func(entryPath string, a *TreeNode, b *TreeNode) {
+		switch {
+		case a == nil && b == nil:
+			return
+		case a == nil:
+			b.Walk(func(node *TreeNode) {
+				entries = append(entries, DiffEntry{
+					Path: "/" + node.Name,
+					Kind: DiffAdded,
+					New:  diffFileInfo(fsB, node),
+				})
+			})
+			return
+		case b == nil:
+			a.Walk(func(node *TreeNode) {
+				entries = append(entries, DiffEntry{
+					Path: "/" + node.Name,
+					Kind: DiffRemoved,
+					Old:  diffFileInfo(fsA, node),
+				})
+			})
+			return
+		}
+
+		entry := DiffEntry{
+			Path: entryPath,
+			Kind: DiffModified,
+			Old:  diffFileInfo(fsA, a),
+			New:  diffFileInfo(fsB, b),
+		}
+		if entry.Old.Type != entry.New.Type {
+			entry.Changes = append(entry.Changes, "type")
+		}
+		if entry.Old.Mode != entry.New.Mode {
+			entry.Changes = append(entry.Changes, "mode")
+		}
+		if entry.Old.Uid != entry.New.Uid || entry.Old.Gid != entry.New.Gid {
+			entry.Changes = append(entry.Changes, "owner")
+		}
+		if entry.Old.Linkname != entry.New.Linkname {
+			entry.Changes = append(entry.Changes, "link")
+		}
+		checkContent := false
+		if a.Typeflag == tar.TypeReg && b.Typeflag == tar.TypeReg {
+			if entry.Old.Size != entry.New.Size {
+				entry.Changes = append(entry.Changes, "size")
+			} else if compareContent && entry.Old.Layer != entry.New.Layer {
+				checkContent = true
+			}
+		}
+		if checkContent {
+			pending = append(pending, len(entries))
+		}
+		if len(entry.Changes) > 0 || checkContent {
+			entries = append(entries, entry)
+		}
+
+		if a.Typeflag != tar.TypeDir && b.Typeflag != tar.TypeDir {
+			return
+		}
+		names := map[string]struct{}{}
+		for childName := range a.Child {
+			names[childName] = struct{}{}
+		}
+		for childName := range b.Child {
+			names[childName] = struct{}{}
+		}
+		sorted := make([]string, 0, len(names))
+		for childName := range names {
+			sorted = append(sorted, childName)
+		}
+		sort.Strings(sorted)
+		for _, childName := range sorted {
+			var childA, childB *TreeNode
+			if a.Typeflag == tar.TypeDir {
+				childA = a.Child[childName]
+			}
+			if b.Typeflag == tar.TypeDir {
+				childB = b.Child[childName]
+			}
+			walk(path.Join(entryPath, childName), childA, childB)
+		}
+	}
+	walk(path.Join("/", prefix), nodeA, nodeB)
+
+	if len(pending) > 0 {
+		if err := s.compareDiffContent(ctx, entries, pending); err != nil {
+			return nil, err
+		}
+		filtered := entries[:0]
+		for _, entry := range entries {
+			if entry.Kind != DiffModified || len(entry.Changes) > 0 {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	return entries, nil
+}
+
+// compareDiffContent calculates content hashes for pending entries and marks changed content.
+func (s *State) compareDiffContent(ctx context.Context, entries []DiffEntry, pending []int) error {
+	type layerFiles struct {
+		layer distribution.Descriptor
+		files map[string]struct{}
+	}
+	layers := map[digest.Digest]*layerFiles{}
+	var order []digest.Digest
+	for _, i := range pending {
+		entryName := strings.TrimPrefix(entries[i].Path, "/")
+		for _, info := range []*DiffFileInfo{entries[i].Old, entries[i].New} {
+			files := layers[info.Layer]
+			if files == nil {
+				files = &layerFiles{
+					layer: info.layer,
+					files: map[string]struct{}{},
+				}
+				layers[info.Layer] = files
+				order = append(order, info.Layer)
+			}
+			files.files[entryName] = struct{}{}
+		}
+	}
+
+	hashes := map[digest.Digest]map[string]digest.Digest{}
+	for _, layerDigest := range order {
+		files := layers[layerDigest]
+		layerHashes, err := s.hashLayerFiles(ctx, files.layer, files.files)
+		if err != nil {
+			return err
+		}
+		hashes[layerDigest] = layerHashes
+	}
+
+	for _, i := range pending {
+		entry := &entries[i]
+		entryName := strings.TrimPrefix(entry.Path, "/")
+		entry.Old.Digest = hashes[entry.Old.Layer][entryName]
+		entry.New.Digest = hashes[entry.New.Layer][entryName]
+		if entry.Old.Digest != entry.New.Digest {
+			entry.Changes = append(entry.Changes, "content")
+		}
+	}
+	return nil
+}
+
+func diffFileInfo(fs *ImageFS, node *TreeNode) *DiffFileInfo {
+	info := &DiffFileInfo{
+		Type:     diffFileType(node.Typeflag),
+		Mode:     node.FileInfo().Mode().String(),
+		Uid:      node.Uid,
+		Gid:      node.Gid,
+		Linkname: node.Linkname,
+	}
+	if node.Typeflag == tar.TypeReg {
+		info.Size = node.Size
+	}
+	if index, ok := fs.Layer(node); ok {
+		info.layer = fs.Layers[index]
+		info.Layer = info.layer.Digest
+	}
+	return info
+}
+
+func diffFileType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return string(typeflag)
+	}
+}
+
+// hashLayerFiles calculates SHA256 of regular files content in layer.
+func (s *State) hashLayerFiles(ctx context.Context, layer distribution.Descriptor, files map[string]struct{}) (map[string]digest.Digest, error) {
+	r, err := s.OpenLayer(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	result := make(map[string]digest.Digest, len(files))
+	t := tar.NewReader(r)
+	for len(result) < len(files) {
+		item, err := t.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		entryName := strings.Trim(path.Clean("/"+item.Name), "/")
+		if _, ok := files[entryName]; !ok || item.Typeflag != tar.TypeReg {
+			continue
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, t); err != nil {
+			return nil, err
+		}
+		result[entryName] = digest.NewDigest(digest.SHA256, hash)
+	}
+	return result, nil
+}
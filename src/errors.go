@@ -1,9 +1,88 @@
 package src
 
-import "github.com/joomcode/errorx"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/joomcode/errorx"
+)
 
 var (
-	Errors = errorx.NewNamespace("docker-build-lte")
+	Errors = errorx.NewNamespace("porter")
 
-	ErrLocalFilesystem = Errors.NewType("invalid_response")
+	// Image is not found in cache or registry
+	ErrImageNotFound = Errors.NewType("image_not_found")
+	// Repository, blob or other registry resource is not found
+	ErrNotFound = Errors.NewType("not_found")
+	// Registry rejected credentials
+	ErrAuth = Errors.NewType("auth")
+	// Transient registry or network failure, that remains after retries
+	ErrRegistryUnavailable = Errors.NewType("registry_unavailable")
+	// Registry access is required in offline mode
+	ErrOffline = Errors.NewType("offline")
+	// Dockerfile syntax error or missing build stage
+	ErrDockerfileParse = Errors.NewType("dockerfile_parse")
+	// Dockerfile instruction can't be applied without container runtime
+	ErrUnsupportedInstruction = Errors.NewType("unsupported_instruction")
+	// Cached state can't be decoded or refers to missing data
+	ErrCacheCorrupted = Errors.NewType("cache_corrupted")
+	// Downloaded content doesn't match expected digest
+	ErrDigestMismatch = Errors.NewType("digest_mismatch")
+	// Image wastes more space than allowed by analyze limit
+	ErrWastedLimit = Errors.NewType("wasted_limit")
 )
+
+// Exit codes for typed errors, other errors exit with code 1.
+var exitCodes = []struct {
+	errorType *errorx.Type
+	code      int
+}{
+	{ErrImageNotFound, 10},
+	{ErrAuth, 11},
+	{ErrRegistryUnavailable, 12},
+	{ErrOffline, 13},
+	{ErrNotFound, 14},
+	{ErrDockerfileParse, 20},
+	{ErrUnsupportedInstruction, 21},
+	{ErrCacheCorrupted, 30},
+	{ErrDigestMismatch, 31},
+	{ErrWastedLimit, 40},
+}
+
+// ErrorOutput is JSON representation of command error.
+type ErrorOutput struct {
+	Error    string `json:"error"`
+	Type     string `json:"type,omitempty"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// ExitCode returns process exit code for error type.
+func ExitCode(err error) int {
+	if e := errorx.Cast(err); e != nil {
+		for _, item := range exitCodes {
+			if e.IsOfType(item.errorType) {
+				return item.code
+			}
+		}
+	}
+	return 1
+}
+
+// WriteError prints error in given format (text or json) and returns process exit code.
+func WriteError(w io.Writer, err error, format string) int {
+	output := ErrorOutput{
+		Error:    err.Error(),
+		ExitCode: ExitCode(err),
+	}
+	if e := errorx.Cast(err); e != nil {
+		output.Type = e.Type().FullName()
+	}
+	if format == "json" {
+		payload, _ := json.Marshal(output)
+		_, _ = fmt.Fprintln(w, string(payload))
+	} else {
+		_, _ = fmt.Fprintln(w, err)
+	}
+	return output.ExitCode
+}
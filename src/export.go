@@ -0,0 +1,146 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+)
+
+// Export writes flattened image root filesystem as single tar archive.
+// Content of every file is read from the topmost layer, which provides it.
+func (s *State) Export(ctx context.Context, image name.Reference, w io.Writer) error {
+	fs, err := s.ImageFS(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	var nodes []*TreeNode
+	fs.Base.Walk(func(node *TreeNode) {
+		if node != fs.Base {
+			nodes = append(nodes, node)
+		}
+	})
+
+	t := tar.NewWriter(w)
+	if err := s.exportNodes(ctx, t, fs, nodes); err != nil {
+		return err
+	}
+	return t.Close()
+}
+
+// exportNodes writes image filesystem entries to tar archive.
+// Directories and other entries without content are written in nodes order, regular files are grouped by layer and hardlinks go last.
+func (s *State) exportNodes(ctx context.Context, t *tar.Writer, fs *ImageFS, nodes []*TreeNode) error {
+	// Layer index -> entry name in layer -> headers for entry content
+	content := make(map[int]map[string][]*tar.Header)
+	addContent := func(index int, entryName string, header *tar.Header) {
+		if content[index] == nil {
+			content[index] = make(map[string][]*tar.Header)
+		}
+		content[index][entryName] = append(content[index][entryName], header)
+	}
+	var hardlinks []*tar.Header
+	for _, node := range nodes {
+		header := exportHeader(node)
+		index, ok := fs.Layer(node)
+		if !ok {
+			return errorx.IllegalState.New("can't find layer for: %s", node.Name)
+		}
+		switch node.Typeflag {
+		case tar.TypeReg:
+			addContent(index, node.Name, header)
+		case tar.TypeLink:
+			linkName := strings.Trim(path.Clean("/"+node.Linkname), "/")
+			if linked := fs.Get(linkName); linked != nil && linked.Typeflag == tar.TypeReg {
+				if linkedIndex, ok := fs.Layer(linked); ok && linkedIndex == index {
+					header.Linkname = linkName
+					hardlinks = append(hardlinks, header)
+					continue
+				}
+			}
+			// Hardlink target is removed or replaced by upper layer: store file content from hardlink layer
+			header.Typeflag = tar.TypeReg
+			header.Linkname = ""
+			addContent(index, linkName, header)
+		default:
+			if err := t.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+
+	for index := range fs.Layers {
+		if files, ok := content[index]; ok {
+			if err := s.exportLayerFiles(ctx, t, fs, index, files); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, header := range hardlinks {
+		if err := t.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *State) exportLayerFiles(ctx context.Context, w *tar.Writer, fs *ImageFS, index int, files map[string][]*tar.Header) error {
+	r, err := s.OpenLayer(ctx, fs.Layers[index])
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	t := tar.NewReader(r)
+	for len(files) > 0 {
+		item, err := t.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		entryName := strings.Trim(path.Clean("/"+item.Name), "/")
+		headers, ok := files[entryName]
+		if !ok || item.Typeflag != tar.TypeReg {
+			continue
+		}
+		delete(files, entryName)
+
+		first := headers[0]
+		first.Size = item.Size
+		if err := w.WriteHeader(first); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, t); err != nil {
+			return err
+		}
+		for _, header := range headers[1:] {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = first.Name
+			header.Size = 0
+			if err := w.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+	for entryName := range files {
+		return errorx.IllegalState.New("can't find file %s in layer: %s", entryName, fs.Layers[index].Digest)
+	}
+	return nil
+}
+
+func exportHeader(node *TreeNode) *tar.Header {
+	header := *node.Header
+	header.Name = node.Name
+	if header.Typeflag == tar.TypeDir {
+		header.Name += "/"
+	}
+	return &header
+}
@@ -0,0 +1,196 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+)
+
+type extractEntry struct {
+	node   *TreeNode
+	target string
+}
+
+// CopyFromImage extracts file or directory subtree from cached image to host filesystem.
+func (s *State) CopyFromImage(ctx context.Context, image name.Reference, source string, dest string) error {
+	fs, err := s.ImageFS(ctx, image)
+	if err != nil {
+		return err
+	}
+	resolved, top, err := fs.Lookup(source, false)
+	if err != nil {
+		return err
+	}
+
+	destRoot := dest
+	if stat, err := os.Stat(dest); err == nil && stat.IsDir() && resolved != "/" {
+		destRoot = path.Join(dest, path.Base(resolved))
+	}
+
+	// Collect entries and layers with regular files content
+	var entries []extractEntry
+	content := make(map[int]map[string][]string)
+	addContent := func(node *TreeNode, target string) error {
+		index, ok := fs.Layer(node)
+		if !ok {
+			return errorx.IllegalState.New("can't find layer for: %s", node.Name)
+		}
+		if content[index] == nil {
+			content[index] = make(map[string][]string)
+		}
+		content[index][node.Name] = append(content[index][node.Name], target)
+		return nil
+	}
+	var walkErr error
+	top.Walk(func(node *TreeNode) {
+		if walkErr != nil {
+			return
+		}
+		target := destRoot
+		if node != top {
+			target = path.Join(destRoot, strings.TrimPrefix(strings.TrimPrefix(node.Name, top.Name), "/"))
+		}
+		entries = append(entries, extractEntry{
+			node:   node,
+			target: target,
+		})
+		switch node.Typeflag {
+		case tar.TypeReg:
+			walkErr = addContent(node, target)
+		case tar.TypeLink:
+			linked := fs.Get(strings.Trim(path.Clean("/"+node.Linkname), "/"))
+			if linked == nil || linked.Typeflag != tar.TypeReg {
+				logrus.Warnf("skip hardlink with unknown target: %s -> %s", node.Name, node.Linkname)
+				return
+			}
+			walkErr = addContent(linked, target)
+		}
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	// Create directories
+	for _, entry := range entries {
+		if entry.node.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(entry.target, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	if len(entries) > 0 && entries[0].node.Typeflag != tar.TypeDir {
+		if err := os.MkdirAll(path.Dir(destRoot), 0755); err != nil {
+			return err
+		}
+	}
+
+	// Stream regular files from layers
+	for index, files := range content {
+		if err := s.extractLayerFiles(ctx, fs, index, files); err != nil {
+			return err
+		}
+	}
+
+	// Create symlinks and restore attributes, directories last
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		node := entry.node
+		switch node.Typeflag {
+		case tar.TypeSymlink:
+			if err := os.Remove(entry.target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(node.Linkname, entry.target); err != nil {
+				return err
+			}
+		case tar.TypeDir, tar.TypeReg, tar.TypeLink:
+		default:
+			logrus.Warnf("skip special file: %s", node.Name)
+			continue
+		}
+		restoreAttributes(entry.target, node)
+	}
+	return nil
+}
+
+// extractLayerFiles writes content of layer files to host targets.
+func (s *State) extractLayerFiles(ctx context.Context, fs *ImageFS, index int, files map[string][]string) error {
+	r, err := s.OpenLayer(ctx, fs.Layers[index])
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	t := tar.NewReader(r)
+	for len(files) > 0 {
+		header, err := t.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		entryName := strings.Trim(path.Clean("/"+header.Name), "/")
+		targets, ok := files[entryName]
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		delete(files, entryName)
+		if err := writeFile(targets[0], t); err != nil {
+			return err
+		}
+		for _, target := range targets[1:] {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Link(targets[0], target); err != nil {
+				return err
+			}
+		}
+	}
+	for entryName := range files {
+		return errorx.IllegalState.New("can't find file %s in layer: %s", entryName, fs.Layers[index].Digest)
+	}
+	return nil
+}
+
+func writeFile(target string, r io.Reader) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func restoreAttributes(target string, node *TreeNode) {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, node.Uid, node.Gid); err != nil {
+			logrus.Warnf("can't change owner of %s: %v", target, err)
+		}
+	}
+	if node.Typeflag == tar.TypeSymlink {
+		return
+	}
+	mode := node.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		logrus.Warnf("can't change mode of %s: %v", target, err)
+	}
+	if err := os.Chtimes(target, node.ModTime, node.ModTime); err != nil {
+		logrus.Warnf("can't change modification time of %s: %v", target, err)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"strings"
 )
 
+// Maximum symlinks count for path resolution (same as Linux MAXSYMLINKS)
+const maxSymlinks = 40
+
 type FS struct {
 	Base  *TreeNode
 	Delta *TreeNode
@@ -16,10 +19,11 @@ func (fs *FS) EvalSymlinks(target string) (string, error) {
 	result := ""
 	base := fs.Base
 	delta := fs.Delta
-	antiLoop := map[string]struct{}{}
+	links := 0
 
 	resolveLink := func(link *TreeNode, target string) (string, error) {
-		if _, ok := antiLoop[link.Name]; ok {
+		links++
+		if links > maxSymlinks {
 			return "", errorx.IllegalState.New("loop detected by symlink: %s", link.Name)
 		}
 
@@ -29,7 +33,7 @@ func (fs *FS) EvalSymlinks(target string) (string, error) {
 
 		linkPath := link.Linkname
 		if !path.IsAbs(linkPath) {
-			linkPath = path.Clean(path.Join(link.Name, linkPath))
+			linkPath = path.Clean(path.Join("/", path.Dir(link.Name), linkPath))
 		}
 		return path.Join(linkPath, target), nil
 	}
@@ -0,0 +1,105 @@
+package src
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+type HistoryItem struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"createdBy"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"emptyLayer,omitempty"`
+	// Compressed layer size
+	Size int64 `json:"size"`
+	// Uncompressed layer size
+	UncompressedSize int64  `json:"uncompressedSize"`
+	Digest           string `json:"digest,omitempty"`
+	DiffID           string `json:"diffId,omitempty"`
+}
+
+// History returns image history with layer information, newest entries first.
+func (s *State) History(ctx context.Context, image name.Reference) ([]HistoryItem, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+
+	configFile, err := s.LoadConfigFile(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	history := configFile.History
+	// Some images have no history for part of layers
+	nonEmpty := 0
+	for _, item := range history {
+		if !item.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	for ; nonEmpty < len(manifest.Layers); nonEmpty++ {
+		history = append(history, v1.History{})
+	}
+
+	items := make([]HistoryItem, 0, len(history))
+	layerIndex := 0
+	for _, entry := range history {
+		item := HistoryItem{
+			Created:    entry.Created.Time,
+			CreatedBy:  entry.CreatedBy,
+			Comment:    entry.Comment,
+			EmptyLayer: entry.EmptyLayer,
+		}
+		if !entry.EmptyLayer && layerIndex < len(manifest.Layers) {
+			layer := manifest.Layers[layerIndex]
+			item.Size = layer.Size
+			item.Digest = layer.Digest.String()
+			if layerIndex < len(configFile.RootFS.DiffIDs) {
+				item.DiffID = configFile.RootFS.DiffIDs[layerIndex].String()
+			}
+			if item.UncompressedSize, err = s.UncompressedSize(ctx, layer); err != nil {
+				return nil, err
+			}
+			layerIndex++
+		}
+		items = append(items, item)
+	}
+
+	// Newest first
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+// UncompressedSize returns uncompressed layer size. Unpacked layer is not stored in cache.
+func (s *State) UncompressedSize(ctx context.Context, layer distribution.Descriptor) (int64, error) {
+	unpacked, err := s.GetUnpackedLayerDescriptor(ctx, layer)
+	if err != nil {
+		return 0, err
+	}
+	if unpacked != nil {
+		return unpacked.Size, nil
+	}
+	if strings.HasSuffix(layer.MediaType, ".tar") {
+		return layer.Size, nil
+	}
+
+	r, err := s.OpenLayer(ctx, layer)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	return io.Copy(io.Discard, r)
+}
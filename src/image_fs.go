@@ -0,0 +1,132 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+)
+
+// ImageFS is merged filesystem of all image layers.
+type ImageFS struct {
+	FS
+	Layers []distribution.Descriptor
+	origin map[*tar.Header]int
+}
+
+// ImageFS returns merged filesystem of cached image.
+func (s *State) ImageFS(ctx context.Context, image name.Reference) (*ImageFS, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	root, origin, err := s.mergeLayerTrees(ctx, manifest.Layers)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageFS{
+		FS: FS{
+			Base: root,
+		},
+		Layers: manifest.Layers,
+		origin: origin,
+	}, nil
+}
+
+// mergeLayerTrees applies layers one by one and remembers index of layer for every tar header.
+func (s *State) mergeLayerTrees(ctx context.Context, layers []distribution.Descriptor) (*TreeNode, map[*tar.Header]int, error) {
+	root := s.EmptyLayer()
+	origin := make(map[*tar.Header]int)
+	if err := s.applyLayerTrees(ctx, root, origin, layers, 0); err != nil {
+		return nil, nil, err
+	}
+	return root, origin, nil
+}
+
+// applyLayerTrees applies layers to root tree. Index of first layer is used as base for layer indexes in origin map.
+func (s *State) applyLayerTrees(ctx context.Context, root *TreeNode, origin map[*tar.Header]int, layers []distribution.Descriptor, first int) error {
+	for i, layer := range layers {
+		fsdiff, err := s.LayerTree(ctx, layer)
+		if err != nil {
+			return err
+		}
+		fsdiff.Walk(func(node *TreeNode) {
+			origin[node.Header] = first + i
+		})
+		root.ApplyDiff(fsdiff)
+	}
+	return nil
+}
+
+// Clone returns copy of tree structure. Tar headers are shared.
+func (t *TreeNode) Clone() *TreeNode {
+	result := &TreeNode{
+		Header: t.Header,
+		Source: t.Source,
+	}
+	if t.Child != nil {
+		result.Child = make(map[string]*TreeNode, len(t.Child))
+		for name, child := range t.Child {
+			result.Child[name] = child.Clone()
+		}
+	}
+	return result
+}
+
+// Walk calls visitor for node and all its children in name order.
+func (t *TreeNode) Walk(visitor func(node *TreeNode)) {
+	visitor(t)
+	for _, name := range t.Names() {
+		t.Child[name].Walk(visitor)
+	}
+}
+
+// Names returns sorted child names.
+func (t *TreeNode) Names() []string {
+	names := make([]string, 0, len(t.Child))
+	for name := range t.Child {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns node by path with symlinks resolution. Last path element symlink is resolved only if follow is true.
+func (f *ImageFS) Lookup(target string, follow bool) (string, *TreeNode, error) {
+	target = path.Clean("/" + target)
+	if follow {
+		resolved, err := f.EvalSymlinks(target)
+		if err != nil {
+			return "", nil, err
+		}
+		target = path.Clean("/" + resolved)
+	} else if target != "/" {
+		dir, err := f.EvalSymlinks(path.Dir(target))
+		if err != nil {
+			return "", nil, err
+		}
+		target = path.Join("/", dir, path.Base(target))
+	}
+	node := f.Base
+	if target != "/" {
+		node = f.Get(strings.TrimPrefix(target, "/"))
+	}
+	if node == nil {
+		return "", nil, errorx.IllegalArgument.New("no such file or directory: %s", target)
+	}
+	return target, node, nil
+}
+
+// Layer returns index of layer, which contains entry.
+func (f *ImageFS) Layer(node *TreeNode) (int, bool) {
+	index, ok := f.origin[node.Header]
+	return index, ok
+}
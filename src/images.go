@@ -2,9 +2,17 @@ package src
 
 import (
 	"context"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+	"github.com/opencontainers/go-digest"
 )
 
 func (s *State) GetImages(ctx context.Context) (map[name.Reference]*schema2.DeserializedManifest, error) {
@@ -25,3 +33,206 @@ func (s *State) GetImages(ctx context.Context) (map[name.Reference]*schema2.Dese
 	}
 	return images, nil
 }
+
+type ImageSummary struct {
+	Repository string            `json:"repository"`
+	Tag        string            `json:"tag"`
+	Digest     digest.Digest     `json:"digest"`
+	ID         digest.Digest     `json:"id"`
+	Created    time.Time         `json:"created"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// Compressed layers size
+	Size int64 `json:"size"`
+	// Uncompressed layers size, zero if unknown
+	UncompressedSize int64 `json:"uncompressedSize,omitempty"`
+	// Image reference without tag
+	Dangling bool `json:"dangling,omitempty"`
+}
+
+// ImageFilter is parsed image list filter.
+type ImageFilter func(image ImageSummary) bool
+
+// ListImages returns cached images matched to all filters sorted by repository and tag.
+func (s *State) ListImages(ctx context.Context, filters ...string) ([]ImageSummary, error) {
+	images, err := s.GetImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ImageSummary, 0, len(images))
+	tagged := make(map[string]struct{})
+	for image, manifest := range images {
+		summary, err := s.imageSummary(ctx, image, manifest)
+		if err != nil {
+			return nil, err
+		}
+		if !summary.Dangling {
+			tagged[summary.Repository+"@"+summary.Digest.String()] = struct{}{}
+		}
+		result = append(result, *summary)
+	}
+	// Digest references of tagged images are not shown separately
+	deduplicated := result[:0]
+	for _, summary := range result {
+		if _, ok := tagged[summary.Repository+"@"+summary.Digest.String()]; ok && summary.Dangling {
+			continue
+		}
+		deduplicated = append(deduplicated, summary)
+	}
+	result = deduplicated
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.ID < b.ID
+	})
+
+	var matchers []ImageFilter
+	for _, filter := range filters {
+		matcher, err := parseImageFilter(filter, result)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	filtered := result[:0]
+	for _, summary := range result {
+		matched := true
+		for _, matcher := range matchers {
+			if !matcher(summary) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			filtered = append(filtered, summary)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *State) imageSummary(ctx context.Context, image name.Reference, manifest *schema2.DeserializedManifest) (*ImageSummary, error) {
+	raw, err := manifest.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	summary := &ImageSummary{
+		Repository: image.Context().RegistryStr() + "/" + image.Context().RepositoryStr(),
+		Tag:        "<none>",
+		Digest:     digest.FromBytes(raw),
+		ID:         manifest.Config.Digest,
+	}
+	if tag, ok := image.(name.Tag); ok {
+		summary.Tag = tag.TagStr()
+	} else {
+		summary.Dangling = true
+	}
+
+	if configFile, err := s.LoadConfigFile(ctx, manifest); err == nil {
+		summary.Created = configFile.Created.Time
+		summary.Labels = configFile.Config.Labels
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	uncompressed := true
+	for _, layer := range manifest.Layers {
+		summary.Size += layer.Size
+		if !uncompressed {
+			continue
+		}
+		if strings.HasSuffix(layer.MediaType, ".tar") {
+			summary.UncompressedSize += layer.Size
+			continue
+		}
+		unpacked, err := s.GetUnpackedLayerDescriptor(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		if unpacked == nil {
+			uncompressed = false
+			summary.UncompressedSize = 0
+			continue
+		}
+		summary.UncompressedSize += unpacked.Size
+	}
+	return summary, nil
+}
+
+// parseImageFilter parses filter in KEY=VALUE format (reference=glob, label=key[=value], before=image, since=image, dangling=bool).
+func parseImageFilter(filter string, images []ImageSummary) (ImageFilter, error) {
+	key, value, ok := strings.Cut(filter, "=")
+	if !ok {
+		return nil, errorx.IllegalArgument.New("filter must be in KEY=VALUE format: %s", filter)
+	}
+	switch key {
+	case "reference":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "invalid reference pattern: %s", value)
+		}
+		return func(image ImageSummary) bool {
+			for _, candidate := range []string{
+				image.Repository,
+				image.Repository + ":" + image.Tag,
+				image.Repository + "@" + image.Digest.String(),
+			} {
+				if matched, _ := path.Match(value, candidate); matched {
+					return true
+				}
+				// Allow pattern without default registry
+				if registry, rest, ok := strings.Cut(candidate, "/"); ok && registry == name.DefaultRegistry {
+					if matched, _ := path.Match(value, strings.TrimPrefix(rest, "library/")); matched {
+						return true
+					}
+					if matched, _ := path.Match(value, rest); matched {
+						return true
+					}
+				}
+			}
+			return false
+		}, nil
+	case "label":
+		labelKey, labelValue, hasValue := strings.Cut(value, "=")
+		return func(image ImageSummary) bool {
+			current, ok := image.Labels[labelKey]
+			return ok && (!hasValue || current == labelValue)
+		}, nil
+	case "before", "since":
+		ref, err := name.ParseReference(value)
+		if err != nil {
+			return nil, err
+		}
+		var created *time.Time
+		for _, image := range images {
+			if image.Repository == ref.Context().RegistryStr()+"/"+ref.Context().RepositoryStr() && (image.Tag == ref.Identifier() || image.Digest.String() == ref.Identifier()) {
+				created = &image.Created
+				break
+			}
+		}
+		if created == nil {
+			return nil, ErrImageNotFound.New("image not found: %s", ref.Name())
+		}
+		if key == "before" {
+			return func(image ImageSummary) bool {
+				return image.Created.Before(*created)
+			}, nil
+		}
+		return func(image ImageSummary) bool {
+			return image.Created.After(*created)
+		}, nil
+	case "dangling":
+		dangling, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "invalid dangling filter value: %s", value)
+		}
+		return func(image ImageSummary) bool {
+			return image.Dangling == dangling
+		}, nil
+	default:
+		return nil, errorx.IllegalArgument.New("unsupported filter: %s", key)
+	}
+}
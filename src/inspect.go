@@ -0,0 +1,265 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/joomcode/errorx"
+)
+
+// InspectImage returns docker compatible information about cached image.
+func (s *State) InspectImage(ctx context.Context, image name.Reference) (*types.ImageInspect, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	configBlob, err := s.ReadBlob(ctx, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	inspect, err := newImageInspect(manifest, configBlob)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range manifest.Layers {
+		size, err := s.UncompressedSize(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		inspect.Size += size
+	}
+	inspect.VirtualSize = inspect.Size
+
+	images, err := s.GetImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for ref, cached := range images {
+		if cached.Config.Digest != manifest.Config.Digest {
+			continue
+		}
+		switch ref := ref.(type) {
+		case name.Digest:
+			inspect.RepoDigests = append(inspect.RepoDigests, FamiliarName(ref.Context())+"@"+ref.DigestStr())
+		case name.Tag:
+			inspect.RepoTags = append(inspect.RepoTags, FamiliarName(ref.Context())+":"+ref.TagStr())
+			tagged, found, err := s.manifestTime(ref)
+			if err != nil {
+				return nil, err
+			}
+			if found && tagged.After(inspect.Metadata.LastTagTime) {
+				inspect.Metadata.LastTagTime = tagged
+			}
+		}
+	}
+	sort.Strings(inspect.RepoTags)
+	sort.Strings(inspect.RepoDigests)
+
+	if inspect.Parent, err = s.parentImage(ctx, manifest, images); err != nil {
+		return nil, err
+	}
+	return inspect, nil
+}
+
+// InspectRemoteImage returns docker compatible information about registry image without pulling layers.
+// Uncompressed size is known only for cached layers, compressed size is used for other ones.
+func (s *State) InspectRemoteImage(ctx context.Context, image name.Reference, platform *v1.Platform) (*types.ImageInspect, error) {
+	remoteImage, err := s.GetRemote(ctx, image, platform)
+	if err != nil {
+		return nil, err
+	}
+	if remoteImage.Index != nil {
+		return nil, errorx.IllegalArgument.New("manifest list %s has no image for platform %s", image.Name(), platform)
+	}
+	var manifest schema2.DeserializedManifest
+	if err := manifest.UnmarshalJSON(remoteImage.RawManifest); err != nil {
+		return nil, err
+	}
+	inspect, err := newImageInspect(&manifest, remoteImage.RawConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range manifest.Layers {
+		unpacked, err := s.GetUnpackedLayerDescriptor(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		if unpacked != nil {
+			inspect.Size += unpacked.Size
+		} else {
+			inspect.Size += layer.Size
+		}
+	}
+	inspect.VirtualSize = inspect.Size
+
+	repoDigest, err := ManifestDigestReference(image, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	inspect.RepoDigests = append(inspect.RepoDigests, FamiliarName(repoDigest.Context())+"@"+repoDigest.DigestStr())
+	if tag, ok := image.(name.Tag); ok {
+		inspect.RepoTags = append(inspect.RepoTags, FamiliarName(tag.Context())+":"+tag.TagStr())
+	}
+	return inspect, nil
+}
+
+// InspectRaw returns cached manifest ("manifest"), image configuration ("config") or both ("raw") without any changes.
+func (s *State) InspectRaw(ctx context.Context, image name.Reference, inspectType string) ([]byte, error) {
+	rawManifest, err := s.LoadRawManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if rawManifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	if inspectType == "manifest" {
+		return rawManifest, nil
+	}
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	rawConfig, err := s.ReadBlob(ctx, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	switch inspectType {
+	case "config":
+		return rawConfig, nil
+	case "raw":
+		var output []byte
+		output = append(output, `{"manifest":`...)
+		output = append(output, rawManifest...)
+		output = append(output, `,"config":`...)
+		output = append(output, rawConfig...)
+		return append(output, '}'), nil
+	default:
+		return nil, errorx.IllegalArgument.New("unsupported type: %s", inspectType)
+	}
+}
+
+// parentImage returns ID of cached image, which image is built from: image with longest history among ones,
+// which layers are first layers of image and history is shorter.
+func (s *State) parentImage(ctx context.Context, manifest *schema2.DeserializedManifest, images map[name.Reference]*schema2.DeserializedManifest) (string, error) {
+	configFile, err := s.LoadConfigFile(ctx, manifest)
+	if err != nil {
+		return "", err
+	}
+	parent := ""
+	parentHistory := 0
+	checked := make(map[string]struct{})
+	for _, cached := range images {
+		id := cached.Config.Digest.String()
+		if _, ok := checked[id]; ok || cached.Config.Digest == manifest.Config.Digest || !layersPrefix(cached, manifest) {
+			continue
+		}
+		checked[id] = struct{}{}
+		cachedConfig, err := s.LoadConfigFile(ctx, cached)
+		if err != nil {
+			return "", err
+		}
+		history := len(cachedConfig.History)
+		if history < len(configFile.History) && (history > parentHistory || (history == parentHistory && id < parent)) {
+			parent = id
+			parentHistory = history
+		}
+	}
+	return parent, nil
+}
+
+// layersPrefix checks that all layers of base image are first layers of image.
+func layersPrefix(base *schema2.DeserializedManifest, image *schema2.DeserializedManifest) bool {
+	if len(base.Layers) > len(image.Layers) {
+		return false
+	}
+	for i, layer := range base.Layers {
+		if layer.Digest != image.Layers[i].Digest {
+			return false
+		}
+	}
+	return true
+}
+
+func newImageInspect(manifest *schema2.DeserializedManifest, configBlob []byte) (*types.ImageInspect, error) {
+	var configFile v1.ConfigFile
+	if err := json.Unmarshal(configBlob, &configFile); err != nil {
+		return nil, err
+	}
+	// Docker specific fields
+	var dockerConfig struct {
+		Comment         string            `json:"comment"`
+		ContainerConfig *container.Config `json:"container_config"`
+	}
+	if err := json.Unmarshal(configBlob, &dockerConfig); err != nil {
+		return nil, err
+	}
+
+	layers := make([]string, 0, len(configFile.RootFS.DiffIDs))
+	for _, layer := range configFile.RootFS.DiffIDs {
+		layers = append(layers, layer.String())
+	}
+	// Image and container configurations have same JSON representation
+	config, err := convertJSON[container.Config](configFile.Config)
+	if err != nil {
+		return nil, err
+	}
+	created := ""
+	if !configFile.Created.IsZero() {
+		created = configFile.Created.Format(time.RFC3339Nano)
+	}
+	return &types.ImageInspect{
+		ID:              manifest.Config.Digest.String(),
+		RepoTags:        []string{},
+		RepoDigests:     []string{},
+		Comment:         dockerConfig.Comment,
+		Created:         created,
+		Container:       configFile.Container,
+		ContainerConfig: dockerConfig.ContainerConfig,
+		DockerVersion:   configFile.DockerVersion,
+		Author:          configFile.Author,
+		Config:          config,
+		Architecture:    configFile.Architecture,
+		Variant:         configFile.Variant,
+		Os:              configFile.OS,
+		OsVersion:       configFile.OSVersion,
+		GraphDriver: types.GraphDriverData{
+			Name: "porter",
+			Data: map[string]string{},
+		},
+		RootFS: types.RootFS{
+			Type:   configFile.RootFS.Type,
+			Layers: layers,
+		},
+	}, nil
+}
+
+// FamiliarName returns repository name in docker short form.
+func FamiliarName(repository name.Repository) string {
+	if repository.RegistryStr() != name.DefaultRegistry {
+		return repository.Name()
+	}
+	return strings.TrimPrefix(repository.RepositoryStr(), "library/")
+}
+
+func convertJSON[T any](value interface{}) (*T, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result T
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,36 @@
+package src
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sirupsen/logrus"
+)
+
+type stateKeychain struct {
+	state *State
+}
+
+// Keychain resolves registry credentials: porter configuration and credentials store first,
+// then docker configuration with credential helpers, anonymous access otherwise.
+func (s *State) Keychain() authn.Keychain {
+	return authn.NewMultiKeychain(
+		&stateKeychain{state: s},
+		authn.DefaultKeychain,
+	)
+}
+
+func (k *stateKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	if authConfig, ok := k.state.config.Auths[registry]; ok {
+		return authn.FromConfig(authConfig), nil
+	}
+	authConfig, err := k.state.credentials.Get(registry)
+	if err != nil {
+		// Broken credentials store must not prevent docker configuration and anonymous access
+		logrus.Warnf("can't get credentials for %s: %v", registry, err)
+		return authn.Anonymous, nil
+	}
+	if authConfig != nil {
+		return authn.FromConfig(*authConfig), nil
+	}
+	return authn.Anonymous, nil
+}
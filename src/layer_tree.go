@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"path"
 	"strings"
 
 	"github.com/blang/vfs"
@@ -28,6 +29,41 @@ func (s *State) EmptyLayer() *TreeNode {
 	}
 }
 
+type layerReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// OpenLayer returns uncompressed layer tar stream.
+func (s *State) OpenLayer(ctx context.Context, blob distribution.Descriptor) (io.ReadCloser, error) {
+	f, err := s.OpenBlob(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(blob.MediaType, ".tar") {
+		return f, nil
+	}
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &layerReader{
+		Reader:  z,
+		closers: []io.Closer{z, f},
+	}, nil
+}
+
+func (r *layerReader) Close() error {
+	var result error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
 func (s *State) LayerTree(ctx context.Context, blob distribution.Descriptor) (*TreeNode, error) {
 	f, err := s.OpenBlob(ctx, blob)
 	if err != nil {
@@ -87,7 +123,7 @@ func (s *State) LayerTree(ctx context.Context, blob distribution.Descriptor) (*T
 }
 
 func (t *TreeNode) Add(tarItem *tar.Header) {
-	full := strings.TrimRight(strings.TrimLeft(tarItem.Name, "/"), "/")
+	full := strings.Trim(path.Clean("/"+tarItem.Name), "/")
 	node := t
 
 	fullpath := node.Name
@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
 	"gopkg.in/yaml.v2"
 )
 
@@ -17,13 +18,48 @@ func (s *State) Login(ctx context.Context, username string, password string, ser
 		return err
 	}
 
-	if s.config.Auths == nil {
-		s.config.Auths = make(map[string]authn.AuthConfig)
-	}
-	s.config.Auths[repo.RegistryStr()] = authn.AuthConfig{
+	if err := s.credentials.Store(repo.RegistryStr(), authn.AuthConfig{
 		Username: username,
 		Password: password,
+	}); err != nil {
+		return err
+	}
+
+	// Drop plaintext credentials from configuration file
+	if _, ok := s.config.Auths[repo.RegistryStr()]; ok {
+		delete(s.config.Auths, repo.RegistryStr())
+		return s.saveConfig()
+	}
+	return nil
+}
+
+func (s *State) Logout(ctx context.Context, server string) error {
+	repo, err := name.NewRegistry(server)
+	if err != nil {
+		return err
 	}
+
+	stored, err := s.credentials.Get(repo.RegistryStr())
+	if err != nil {
+		return err
+	}
+	_, configured := s.config.Auths[repo.RegistryStr()]
+	if stored == nil && !configured {
+		return errorx.IllegalArgument.New("not logged in to %s", repo.RegistryStr())
+	}
+	if stored != nil {
+		if err := s.credentials.Erase(repo.RegistryStr()); err != nil {
+			return err
+		}
+	}
+	if configured {
+		delete(s.config.Auths, repo.RegistryStr())
+		return s.saveConfig()
+	}
+	return nil
+}
+
+func (s *State) saveConfig() error {
 	_ = os.MkdirAll(path.Dir(s.configFile), 0755)
 
 	config, err := yaml.Marshal(s.config)
@@ -0,0 +1,170 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/joomcode/errorx"
+)
+
+type MutateOptions struct {
+	Labels map[string]string
+	// Environment variables in KEY=VALUE format
+	Env []string
+	// Command line in JSON array or shell form, nil to keep current value
+	Entrypoint *string
+	Cmd        *string
+	User       *string
+	WorkingDir *string
+	// Ports in PORT[/PROTOCOL] format
+	ExposedPorts []string
+	// Docker schema2 manifest has no annotations, so they are stored in config descriptor
+	Annotations map[string]string
+}
+
+// Mutate changes image configuration and saves result as target image. Image layers are not changed.
+func (s *State) Mutate(ctx context.Context, image name.Reference, target name.Reference, options MutateOptions) (*schema2.DeserializedManifest, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	configFile, err := s.LoadConfigFile(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &configFile.Config
+	var changes []string
+	if len(options.Labels) > 0 {
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		keys := make([]string, 0, len(options.Labels))
+		for key := range options.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			config.Labels[key] = options.Labels[key]
+			changes = append(changes, fmt.Sprintf("LABEL %s=%s", key, options.Labels[key]))
+		}
+	}
+	for _, env := range options.Env {
+		if !strings.Contains(env, "=") {
+			return nil, errorx.IllegalArgument.New("environment variable must be in KEY=VALUE format: %s", env)
+		}
+		config.Env = setEnv(config.Env, env)
+		changes = append(changes, "ENV "+env)
+	}
+	if options.Entrypoint != nil {
+		args, err := parseCommandLine(*options.Entrypoint, configFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Entrypoint = args
+		if options.Cmd == nil {
+			config.Cmd = nil
+		}
+		changes = append(changes, "ENTRYPOINT "+*options.Entrypoint)
+	}
+	if options.Cmd != nil {
+		args, err := parseCommandLine(*options.Cmd, configFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Cmd = args
+		changes = append(changes, "CMD "+*options.Cmd)
+	}
+	if options.User != nil {
+		config.User = *options.User
+		changes = append(changes, "USER "+*options.User)
+	}
+	if options.WorkingDir != nil {
+		config.WorkingDir = *options.WorkingDir
+		changes = append(changes, "WORKDIR "+*options.WorkingDir)
+	}
+	for _, port := range options.ExposedPorts {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(map[string]struct{})
+		}
+		config.ExposedPorts[port] = struct{}{}
+		changes = append(changes, "EXPOSE "+port)
+	}
+	if len(changes) > 0 {
+		configFile.History = append(configFile.History, v1.History{
+			Created: v1.Time{
+				Time: time.Now().UTC(),
+			},
+			CreatedBy:  "porter mutate " + strings.Join(changes, "; "),
+			EmptyLayer: true,
+		})
+	}
+
+	descriptor, err := s.saveConfigFile(ctx, configFile, manifest.Config.Platform)
+	if err != nil {
+		return nil, err
+	}
+	descriptor.Annotations = manifest.Config.Annotations
+	if len(options.Annotations) > 0 {
+		annotations := make(map[string]string, len(descriptor.Annotations)+len(options.Annotations))
+		for key, value := range descriptor.Annotations {
+			annotations[key] = value
+		}
+		for key, value := range options.Annotations {
+			annotations[key] = value
+		}
+		descriptor.Annotations = annotations
+	}
+	result, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    *descriptor,
+		Layers:    manifest.Layers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveManifest(ctx, result, target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setEnv replaces or appends environment variable in KEY=VALUE format.
+func setEnv(env []string, item string) []string {
+	key := strings.SplitN(item, "=", 2)[0]
+	result := make([]string, 0, len(env)+1)
+	for _, current := range env {
+		if strings.SplitN(current, "=", 2)[0] != key {
+			result = append(result, current)
+		}
+	}
+	return append(result, item)
+}
+
+// parseCommandLine parses command line in JSON array form or wraps it with shell like Dockerfile shell form.
+func parseCommandLine(cmdLine string, configFile *v1.ConfigFile) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(cmdLine), "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(cmdLine), &args); err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "invalid command line: %s", cmdLine)
+		}
+		return args, nil
+	}
+	if cmdLine == "" {
+		return nil, nil
+	}
+	return append(getShell(configFile.Config, configFile.OS), cmdLine), nil
+}
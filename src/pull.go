@@ -2,21 +2,30 @@ package src
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sort"
+	"time"
 
 	"github.com/blang/vfs"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/joomcode/errorx"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
 )
 
 var bucketManifest = "manifest.v1"
 
+// Digest references recorded implicitly on pull or push of tag
+var bucketImplicitDigest = "implicit.v1"
+
 func (s *State) Pull(ctx context.Context, image name.Reference, allowCached bool) (*schema2.DeserializedManifest, error) {
 	manifest, err := s.PullManifest(ctx, image, allowCached)
 	if err != nil {
@@ -35,46 +44,162 @@ func (s *State) Pull(ctx context.Context, image name.Reference, allowCached bool
 }
 
 func (s *State) PullManifest(ctx context.Context, image name.Reference, allowCached bool) (*schema2.DeserializedManifest, error) {
-	if allowCached {
+	// Cache is the only manifest source in offline mode
+	var stale *schema2.DeserializedManifest
+	if allowCached || s.offline {
 		cached, err := s.LoadManifest(ctx, image)
 		if err != nil {
 			return nil, err
 		}
 		if cached != nil {
-			return cached, nil
+			fresh, err := s.manifestFresh(image)
+			if err != nil {
+				return nil, err
+			}
+			if fresh || s.offline {
+				return cached, nil
+			}
+			logrus.Infof("cached manifest expired: %s", image.Name())
+			stale = cached
 		}
 	}
 
-	desc, err := remote.Image(image, s.RemoveOptions(image)...)
-	if err != nil {
+	var rawManifest []byte
+	if err := s.withMirrors(image, func(ref name.Reference) error {
+		desc, err := remote.Image(ref, s.RemoveOptions(ref)...)
+		if err != nil {
+			return err
+		}
+		rawManifest, err = desc.RawManifest()
+		return err
+	}); err != nil {
+		// Expired manifest is still better than nothing while registry is down
+		if stale != nil && errorx.IsOfType(err, ErrRegistryUnavailable) {
+			logrus.Warnf("using expired cached manifest %s: %v", image.Name(), err)
+			return stale, nil
+		}
 		return nil, err
 	}
 
 	var manifest schema2.DeserializedManifest
-	rawManifest, err := desc.RawManifest()
-	if err != nil {
-		return nil, err
-	}
 	if err := manifest.UnmarshalJSON(rawManifest); err != nil {
 		return nil, err
 	}
+	if stale != nil {
+		if err := warnManifestUpdate(image, stale, &manifest); err != nil {
+			return nil, err
+		}
+	}
 	if err := s.SaveManifest(ctx, &manifest, image); err != nil {
 		return nil, err
 	}
+	// Record digest reference to make image available by digest
+	if err := s.saveImplicitDigest(ctx, &manifest, image.Context().Digest(digest.FromBytes(rawManifest).String())); err != nil {
+		return nil, err
+	}
 	return &manifest, nil
 }
 
-func (s *State) LoadManifest(ctx context.Context, image name.Reference) (*schema2.DeserializedManifest, error) {
-	var cached []byte
-	cached, found, err := s.cacheLoad(bucketManifest, image.Name())
+// manifestFresh checks cached manifest age against configured TTL. Manifests referenced by digest never expire.
+func (s *State) manifestFresh(image name.Reference) (bool, error) {
+	if _, ok := image.(name.Digest); ok {
+		return true, nil
+	}
+	ttl := s.config.GetManifestTTL(image.Context())
+	if ttl <= 0 {
+		return true, nil
+	}
+	saved, found, err := s.manifestTime(image)
 	if err != nil || !found {
+		return false, err
+	}
+	return time.Since(saved) < ttl, nil
+}
+
+// manifestTime returns time of last cached manifest save.
+func (s *State) manifestTime(image name.Reference) (time.Time, bool, error) {
+	stat, err := s.stateVfs.Stat(s.cacheFile(bucketManifest, image.Name()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	return stat.ModTime(), true, nil
+}
+
+// Resolve returns digest reference of image manifest. Manifest is fetched from registry unless allowCached is set
+// and image is already cached.
+func (s *State) Resolve(ctx context.Context, image name.Reference, allowCached bool) (name.Digest, error) {
+	manifest, err := s.PullManifest(ctx, image, allowCached)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return ManifestDigestReference(image, manifest)
+}
+
+// warnManifestUpdate reports change of cached image manifest after refresh from registry.
+func warnManifestUpdate(image name.Reference, previous *schema2.DeserializedManifest, manifest *schema2.DeserializedManifest) error {
+	before, err := ManifestDigestReference(image, previous)
+	if err != nil {
+		return err
+	}
+	after, err := ManifestDigestReference(image, manifest)
+	if err != nil {
+		return err
+	}
+	if before != after {
+		logrus.Warnf("image %s updated: %s -> %s", image.Name(), before.DigestStr(), after.DigestStr())
+	}
+	return nil
+}
+
+// ManifestDigestReference returns image repository reference with manifest digest.
+func ManifestDigestReference(image name.Reference, manifest *schema2.DeserializedManifest) (name.Digest, error) {
+	raw, err := manifest.MarshalJSON()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return image.Context().Digest(digest.FromBytes(raw).String()), nil
+}
+
+// RepoDigests returns cached digest references of manifests with same image configuration.
+func (s *State) RepoDigests(ctx context.Context, manifest *schema2.DeserializedManifest) ([]name.Digest, error) {
+	images, err := s.GetImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []name.Digest
+	for image, cached := range images {
+		if ref, ok := image.(name.Digest); ok && cached.Config.Digest == manifest.Config.Digest {
+			result = append(result, ref)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+func (s *State) LoadManifest(ctx context.Context, image name.Reference) (*schema2.DeserializedManifest, error) {
+	cached, err := s.LoadRawManifest(ctx, image)
+	if err != nil || cached == nil {
 		return nil, err
 	}
 	var manifest schema2.DeserializedManifest
-	if err := manifest.UnmarshalJSON(cached); err == nil {
-		return &manifest, nil
+	if err := manifest.UnmarshalJSON(cached); err != nil {
+		return nil, ErrCacheCorrupted.Wrap(err, "can't decode cached manifest: %s", image.Name())
 	}
-	return nil, nil
+	return &manifest, nil
+}
+
+// LoadRawManifest returns cached manifest exactly as stored or nil if image is not cached.
+func (s *State) LoadRawManifest(ctx context.Context, image name.Reference) ([]byte, error) {
+	cached, found, err := s.cacheLoad(bucketManifest, image.Name())
+	if err != nil || !found {
+		return nil, err
+	}
+	return cached, nil
 }
 
 func (s *State) SaveManifest(ctx context.Context, manifest *schema2.DeserializedManifest, image name.Reference) error {
@@ -82,7 +207,35 @@ func (s *State) SaveManifest(ctx context.Context, manifest *schema2.Deserialized
 	if err != nil {
 		return err
 	}
-	return s.cacheSave(bucketManifest, image.Name(), cached)
+	if err := s.cacheSave(bucketManifest, image.Name(), cached); err != nil {
+		return err
+	}
+	// Digest reference is saved explicitly
+	if _, ok := image.(name.Digest); ok {
+		if err := s.cacheRemove(bucketImplicitDigest, image.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveImplicitDigest records digest reference of pulled or pushed tag. Implicit digest reference is removed
+// with last tag of manifest, already cached digest reference is kept as is.
+func (s *State) saveImplicitDigest(ctx context.Context, manifest *schema2.DeserializedManifest, image name.Digest) error {
+	cached, err := s.LoadRawManifest(ctx, image)
+	if err != nil || cached != nil {
+		return err
+	}
+	if err := s.SaveManifest(ctx, manifest, image); err != nil {
+		return err
+	}
+	return s.cacheSave(bucketImplicitDigest, image.Name(), nil)
+}
+
+// implicitDigest checks that digest reference was recorded implicitly.
+func (s *State) implicitDigest(image name.Digest) (bool, error) {
+	_, found, err := s.cacheLoad(bucketImplicitDigest, image.Name())
+	return found, err
 }
 
 func (s *State) blobName(blob distribution.Descriptor, suffix string) string {
@@ -116,6 +269,19 @@ func (s *State) ReadBlob(ctx context.Context, blob distribution.Descriptor) ([]b
 	return vfs.ReadFile(s.stateVfs, s.blobName(blob, ""))
 }
 
+// LoadConfigFile reads image configuration of cached manifest.
+func (s *State) LoadConfigFile(ctx context.Context, manifest *schema2.DeserializedManifest) (*v1.ConfigFile, error) {
+	blob, err := s.ReadBlob(ctx, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	var configFile v1.ConfigFile
+	if err := json.Unmarshal(blob, &configFile); err != nil {
+		return nil, ErrCacheCorrupted.Wrap(err, "can't decode image configuration: %s", manifest.Config.Digest)
+	}
+	return &configFile, nil
+}
+
 func (s *State) DownloadBlob(ctx context.Context, image name.Reference, blob distribution.Descriptor) (string, error) {
 	filename := s.blobName(blob, "")
 	digest := blob.Digest
@@ -123,31 +289,49 @@ func (s *State) DownloadBlob(ctx context.Context, image name.Reference, blob dis
 	_, err := s.stateVfs.Stat(filename)
 	if err == nil {
 		// Already downloaded
+		if source, err := s.LoadBlobSource(ctx, blob); err == nil && source == nil {
+			if err := s.SaveBlobSource(ctx, blob, image.Context()); err != nil {
+				return "", err
+			}
+		}
 		return filename, nil
 	}
 	if !os.IsNotExist(err) {
 		return "", errorx.InternalError.Wrap(err, "can't get file state: %s", filename)
 	}
 
-	layerDigest := image.Context().Digest(blob.Digest.String())
-	layer, err := remote.Layer(layerDigest, s.RemoveOptions(image)...)
-	if err != nil {
-		return "", err
-	}
+	var source name.Repository
+	if err := s.withMirrors(image.Context().Digest(blob.Digest.String()), func(layerDigest name.Reference) error {
+		layer, err := remote.Layer(layerDigest.(name.Digest), append(s.RemoveOptions(layerDigest), remote.WithContext(ctx))...)
+		if err != nil {
+			return err
+		}
 
-	reader, err := layer.Compressed()
-	if err != nil {
-		return "", err
-	}
-	defer reader.Close()
+		// Broken download is started again
+		return s.withRetry(ctx, func() error {
+			reader, err := layer.Compressed()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
 
-	if err := safeWrite(s.stateVfs, filename, func(w io.Writer) error {
-		if _, err := io.Copy(w, reader); err != nil {
-			return errorx.InternalError.Wrap(err, "error on downloading blob: %s", digest)
-		}
-		return nil
+			source = layerDigest.Context()
+			return safeWrite(s.stateVfs, filename, func(w io.Writer) error {
+				verifier := digest.Verifier()
+				if _, err := io.Copy(io.MultiWriter(w, verifier), reader); err != nil {
+					return errorx.InternalError.Wrap(err, "error on downloading blob: %s", digest)
+				}
+				if !verifier.Verified() {
+					return ErrDigestMismatch.New("downloaded blob doesn't match digest: %s", digest)
+				}
+				return nil
+			})
+		})
 	}); err != nil {
 		return "", err
 	}
+	if err := s.SaveBlobSource(ctx, blob, source); err != nil {
+		return "", err
+	}
 	return filename, nil
 }
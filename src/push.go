@@ -3,34 +3,83 @@ package src
 import (
 	"context"
 
+	"github.com/docker/distribution"
+	"github.com/docker/go-units"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
 )
 
-func (s *State) Push(ctx context.Context, images ...string) error {
+type PushResult struct {
+	Image name.Reference
+	// Total size of image blobs
+	Size int64
+	// Size of blobs, that was really uploaded (not mounted and not already exists in registry)
+	Uploaded int64
+}
+
+func (s *State) Push(ctx context.Context, images ...string) ([]PushResult, error) {
 	infos := make([]name.Reference, 0, len(images))
 	// Resolve images
 	for _, image := range images {
 		info, err := name.ParseReference(image)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		infos = append(infos, info)
 	}
 	// Push manifests
+	results := make([]PushResult, 0, len(infos))
 	for _, image := range infos {
 		manifest, err := s.LoadManifest(ctx, image)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if manifest == nil {
-			return errorx.IllegalArgument.New("can't find manifest for: %s", image.Name())
+			return nil, ErrImageNotFound.New("can't find manifest for: %s", image.Name())
+		}
+
+		stateImage := s.NewImage(ctx, manifest).(*stateImage)
+		target := s.RemoteReference(image)
+		if err := s.checkOnline(target.Name()); err != nil {
+			return nil, err
+		}
+		if err := remote.Write(target, stateImage, append(s.RemoveOptions(target), remote.WithContext(ctx))...); err != nil {
+			return nil, remoteError(err, target.Name())
+		}
+		// Pushed blobs can be mounted to other repositories of registry
+		for _, blob := range append([]distribution.Descriptor{manifest.Config}, manifest.Layers...) {
+			source, err := s.LoadBlobSource(ctx, blob)
+			if err != nil {
+				return nil, err
+			}
+			if source != nil {
+				continue
+			}
+			if err := s.SaveBlobSource(ctx, blob, target.Context()); err != nil {
+				return nil, err
+			}
+		}
+		// Pushed manifest is available in registry by digest
+		repoDigest, err := ManifestDigestReference(image, manifest)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.saveImplicitDigest(ctx, manifest, repoDigest); err != nil {
+			return nil, err
 		}
 
-		if err := remote.Write(image, s.NewImage(ctx, manifest), s.RemoveOptions(image)...); err != nil {
-			return err
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		result := PushResult{
+			Image:    image,
+			Size:     size,
+			Uploaded: stateImage.Uploaded(),
 		}
+		logrus.Infof("image pushed: %s, uploaded %s of %s", image.Name(), units.HumanSize(float64(result.Uploaded)), units.HumanSize(float64(result.Size)))
+		results = append(results, result)
 	}
-	return nil
+	return results, nil
 }
@@ -0,0 +1,219 @@
+package src
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+)
+
+// Rebase replaces old base image layers of cached image by new base image layers and saves result as target image.
+// Configuration values inherited from old base image are replaced by new base image values.
+func (s *State) Rebase(ctx context.Context, image name.Reference, oldBase name.Reference, newBase name.Reference, target name.Reference) (*schema2.DeserializedManifest, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	// Only configuration of old base image is needed
+	oldManifest, err := s.PullManifest(ctx, oldBase, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.DownloadBlob(ctx, oldBase, oldManifest.Config); err != nil {
+		return nil, err
+	}
+	newManifest, err := s.Pull(ctx, newBase, true)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile, err := s.LoadConfigFile(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+	oldConfig, err := s.LoadConfigFile(ctx, oldManifest)
+	if err != nil {
+		return nil, err
+	}
+	newConfig, err := s.LoadConfigFile(ctx, newManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that image is based on old base image
+	oldDiffIDs := oldConfig.RootFS.DiffIDs
+	if len(configFile.RootFS.DiffIDs) < len(oldDiffIDs) || len(manifest.Layers) != len(configFile.RootFS.DiffIDs) {
+		return nil, errorx.IllegalArgument.New("image %s is not based on %s", image.Name(), oldBase.Name())
+	}
+	for i, diffID := range oldDiffIDs {
+		if configFile.RootFS.DiffIDs[i] != diffID {
+			return nil, errorx.IllegalArgument.New("image %s is not based on %s: layer #%d differs (%s != %s)", image.Name(), oldBase.Name(), i, configFile.RootFS.DiffIDs[i], diffID)
+		}
+	}
+	if len(configFile.History) < len(oldConfig.History) {
+		return nil, errorx.IllegalArgument.New("image %s has shorter history than base image %s", image.Name(), oldBase.Name())
+	}
+	// History entries are replaced together with layers, so history must be split at same place
+	if historyLayers(oldConfig.History) != len(oldDiffIDs) {
+		return nil, errorx.IllegalArgument.New("history of base image %s doesn't match its %d layers", oldBase.Name(), len(oldDiffIDs))
+	}
+	for i, history := range oldConfig.History {
+		if !historyEqual(configFile.History[i], history) {
+			return nil, errorx.IllegalArgument.New("image %s is not based on %s: history entry #%d differs (%q != %q)", image.Name(), oldBase.Name(), i, configFile.History[i].CreatedBy, history.CreatedBy)
+		}
+	}
+	if historyLayers(configFile.History[len(oldConfig.History):]) != len(configFile.RootFS.DiffIDs)-len(oldDiffIDs) {
+		return nil, errorx.IllegalArgument.New("history of image %s doesn't match its layers", image.Name())
+	}
+
+	layers := make([]distribution.Descriptor, 0, len(newManifest.Layers)+len(manifest.Layers)-len(oldDiffIDs))
+	layers = append(layers, newManifest.Layers...)
+	layers = append(layers, manifest.Layers[len(oldDiffIDs):]...)
+
+	rebased := *configFile
+	rebased.Architecture = newConfig.Architecture
+	rebased.OS = newConfig.OS
+	rebased.OSVersion = newConfig.OSVersion
+	rebased.Variant = newConfig.Variant
+	rebased.RootFS.DiffIDs = append(append([]v1.Hash{}, newConfig.RootFS.DiffIDs...), configFile.RootFS.DiffIDs[len(oldDiffIDs):]...)
+	rebased.History = append(append([]v1.History{}, newConfig.History...), configFile.History[len(oldConfig.History):]...)
+	rebased.Config = rebaseConfig(configFile.Config, oldConfig.Config, newConfig.Config)
+
+	config, err := s.saveConfigFile(ctx, &rebased, manifest.Config.Platform)
+	if err != nil {
+		return nil, err
+	}
+	result, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    *config,
+		Layers:    layers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveManifest(ctx, result, target); err != nil {
+		return nil, err
+	}
+	logrus.Infof("rebased %s: replaced %d base layers by %d layers", image.Name(), len(oldDiffIDs), len(newManifest.Layers))
+	return result, nil
+}
+
+// historyLayers returns number of history entries, which produce layer.
+func historyLayers(history []v1.History) int {
+	count := 0
+	for _, item := range history {
+		if !item.EmptyLayer {
+			count++
+		}
+	}
+	return count
+}
+
+func historyEqual(a v1.History, b v1.History) bool {
+	return a.Created.Equal(b.Created.Time) &&
+		a.CreatedBy == b.CreatedBy &&
+		a.Author == b.Author &&
+		a.Comment == b.Comment &&
+		a.EmptyLayer == b.EmptyLayer
+}
+
+// rebaseConfig returns image configuration, where values inherited from old base are replaced by new base values.
+func rebaseConfig(config v1.Config, oldBase v1.Config, newBase v1.Config) v1.Config {
+	result := config
+	result.Env = rebaseEnv(config.Env, oldBase.Env, newBase.Env)
+	result.Labels = rebaseLabels(config.Labels, oldBase.Labels, newBase.Labels)
+	if reflect.DeepEqual(config.Entrypoint, oldBase.Entrypoint) && reflect.DeepEqual(config.Cmd, oldBase.Cmd) {
+		result.Entrypoint = newBase.Entrypoint
+		result.Cmd = newBase.Cmd
+	}
+	if config.User == oldBase.User {
+		result.User = newBase.User
+	}
+	if config.WorkingDir == oldBase.WorkingDir {
+		result.WorkingDir = newBase.WorkingDir
+	}
+	if config.StopSignal == oldBase.StopSignal {
+		result.StopSignal = newBase.StopSignal
+	}
+	if reflect.DeepEqual(config.Shell, oldBase.Shell) {
+		result.Shell = newBase.Shell
+	}
+	if reflect.DeepEqual(config.Healthcheck, oldBase.Healthcheck) {
+		result.Healthcheck = newBase.Healthcheck
+	}
+	if reflect.DeepEqual(config.OnBuild, oldBase.OnBuild) {
+		result.OnBuild = newBase.OnBuild
+	}
+	result.ExposedPorts = rebaseSet(config.ExposedPorts, oldBase.ExposedPorts, newBase.ExposedPorts)
+	result.Volumes = rebaseSet(config.Volumes, oldBase.Volumes, newBase.Volumes)
+	return result
+}
+
+// rebaseEnv keeps variables changed by image on top of new base environment.
+func rebaseEnv(env []string, oldBase []string, newBase []string) []string {
+	inherited := make(map[string]struct{}, len(oldBase))
+	for _, item := range oldBase {
+		inherited[item] = struct{}{}
+	}
+	result := make([]string, 0, len(newBase)+len(env))
+	index := make(map[string]int)
+	for _, item := range newBase {
+		index[strings.SplitN(item, "=", 2)[0]] = len(result)
+		result = append(result, item)
+	}
+	for _, item := range env {
+		if _, ok := inherited[item]; ok {
+			continue
+		}
+		key := strings.SplitN(item, "=", 2)[0]
+		if i, ok := index[key]; ok {
+			result[i] = item
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, item)
+	}
+	return result
+}
+
+func rebaseLabels(labels map[string]string, oldBase map[string]string, newBase map[string]string) map[string]string {
+	if len(labels) == 0 && len(newBase) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(labels)+len(newBase))
+	for key, value := range newBase {
+		result[key] = value
+	}
+	for key, value := range labels {
+		if oldValue, ok := oldBase[key]; ok && oldValue == value {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func rebaseSet(values map[string]struct{}, oldBase map[string]struct{}, newBase map[string]struct{}) map[string]struct{} {
+	if len(values) == 0 && len(newBase) == 0 {
+		return nil
+	}
+	result := make(map[string]struct{}, len(values)+len(newBase))
+	for key := range newBase {
+		result[key] = struct{}{}
+	}
+	for key := range values {
+		if _, ok := oldBase[key]; !ok {
+			result[key] = struct{}{}
+		}
+	}
+	return result
+}
@@ -1,15 +1,131 @@
 package src
 
 import (
-	"github.com/google/go-containerregistry/pkg/authn"
+	"net/http"
+	"sort"
+	"strings"
+
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
 )
 
 func (s *State) RemoveOptions(ref name.Reference) []remote.Option {
-	var opts []remote.Option
-	if authConfig, ok := s.config.Auths[ref.Context().RegistryStr()]; ok {
-		opts = append(opts, remote.WithAuth(authn.FromConfig(authConfig)))
+	return s.registryOptions(ref.Context().Registry)
+}
+
+// registryOptions returns remote options with credentials and transport for registry.
+func (s *State) registryOptions(registry name.Registry) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(s.Keychain()),
+		remote.WithTransport(s.transport(registry)),
+		// Requests are retried by own transport only
+		remote.WithRetryStatusCodes(),
+		remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
+	}
+}
+
+// checkOnline returns error if registry access for reference is required in offline mode.
+func (s *State) checkOnline(ref string) error {
+	if s.offline {
+		return ErrOffline.New("can't access registry in offline mode: %s", ref)
+	}
+	return nil
+}
+
+// transport returns HTTP transport for registry.
+func (s *State) transport(registry name.Registry) http.RoundTripper {
+	if transport, ok := s.transports[registry.RegistryStr()]; ok {
+		return transport
+	}
+	return s.defaultTransport
+}
+
+// RemoteReference applies registry rewrite rules to reference.
+func (s *State) RemoteReference(ref name.Reference) name.Reference {
+	repo := ref.Context()
+	config := s.config.GetRegistry(repo.RegistryStr())
+	target := repo.Name()
+	// Most specific rule wins
+	patterns := make([]string, 0, len(config.Rewrite))
+	for pattern := range config.Rewrite {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if rewritten, ok := rewriteRepository(repo.RepositoryStr(), pattern, config.Rewrite[pattern]); ok {
+			target = rewritten
+			break
+		}
+	}
+	if target == repo.Name() && !config.HTTP {
+		return ref
+	}
+	return s.withRepository(ref, target)
+}
+
+// MirrorReferences returns references for pull in priority order: mirrors first, then upstream.
+func (s *State) MirrorReferences(ref name.Reference) []name.Reference {
+	ref = s.RemoteReference(ref)
+	repo := ref.Context()
+	config := s.config.GetRegistry(repo.RegistryStr())
+	refs := make([]name.Reference, 0, len(config.Mirrors)+1)
+	for _, mirror := range config.Mirrors {
+		refs = append(refs, s.withRepository(ref, strings.TrimRight(mirror, "/")+"/"+repo.RepositoryStr()))
+	}
+	return append(refs, ref)
+}
+
+// withMirrors calls task for mirror references until first success.
+func (s *State) withMirrors(ref name.Reference, task func(ref name.Reference) error) error {
+	if err := s.checkOnline(ref.Name()); err != nil {
+		return err
+	}
+	var err error
+	for _, mirror := range s.MirrorReferences(ref) {
+		if err = task(mirror); err == nil {
+			return nil
+		}
+		logrus.Warnf("can't get %s: %v", mirror.Name(), err)
+	}
+	return remoteError(err, ref.Name())
+}
+
+// withRepository returns reference with same tag or digest in another repository.
+func (s *State) withRepository(ref name.Reference, repository string) name.Reference {
+	var opts []name.Option
+	if registry, err := name.NewRegistry(strings.SplitN(repository, "/", 2)[0]); err == nil {
+		if s.config.GetRegistry(registry.RegistryStr()).HTTP {
+			opts = append(opts, name.Insecure)
+		}
+	}
+	repo, err := name.NewRepository(repository, opts...)
+	if err != nil {
+		logrus.Warnf("invalid repository %s: %v", repository, err)
+		return ref
+	}
+	switch ref := ref.(type) {
+	case name.Digest:
+		return repo.Digest(ref.DigestStr())
+	default:
+		return repo.Tag(ref.Identifier())
+	}
+}
+
+func rewriteRepository(repository string, pattern string, replace string) (string, bool) {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		if suffix, ok := strings.CutPrefix(repository, prefix); ok {
+			return strings.Replace(replace, "*", suffix, 1), true
+		}
+		return "", false
+	}
+	if repository == pattern {
+		return replace, true
 	}
-	return opts
+	return "", false
 }
@@ -0,0 +1,100 @@
+package src
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/joomcode/errorx"
+)
+
+type RemoteImage struct {
+	// Reference of fetched manifest (may be mirror or platform specific digest reference)
+	Reference   name.Reference  `json:"-"`
+	Name        string          `json:"name"`
+	Digest      v1.Hash         `json:"digest"`
+	MediaType   types.MediaType `json:"mediaType"`
+	RawManifest []byte          `json:"-"`
+	// Filled for manifest list
+	Index *v1.IndexManifest `json:"index,omitempty"`
+	// Filled for image manifest
+	Manifest   *v1.Manifest   `json:"manifest,omitempty"`
+	RawConfig  []byte         `json:"-"`
+	ConfigFile *v1.ConfigFile `json:"config,omitempty"`
+}
+
+// GetRemote fetches manifest and image configuration from registry without layers downloading and local cache changes.
+// Manifest list is resolved to platform image if platform is set.
+func (s *State) GetRemote(ctx context.Context, image name.Reference, platform *v1.Platform) (*RemoteImage, error) {
+	var desc *remote.Descriptor
+	var source name.Reference
+	if err := s.withMirrors(image, func(ref name.Reference) error {
+		var err error
+		desc, err = remote.Get(ref, append(s.RemoveOptions(ref), remote.WithContext(ctx))...)
+		source = ref
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType.IsIndex() && platform != nil {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var child *v1.Descriptor
+		for i, manifest := range indexManifest.Manifests {
+			if manifest.Platform != nil && manifest.Platform.Satisfies(*platform) {
+				child = &indexManifest.Manifests[i]
+				break
+			}
+		}
+		if child == nil {
+			return nil, errorx.IllegalArgument.New("no manifest for platform %s in %s", platform, image.Name())
+		}
+		source = source.Context().Digest(child.Digest.String())
+		if desc, err = remote.Get(source, append(s.RemoveOptions(source), remote.WithContext(ctx))...); err != nil {
+			return nil, remoteError(err, source.Name())
+		}
+	}
+
+	result := &RemoteImage{
+		Reference:   source,
+		Name:        source.Name(),
+		Digest:      desc.Digest,
+		MediaType:   desc.MediaType,
+		RawManifest: desc.Manifest,
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		if result.Index, err = index.IndexManifest(); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	remoteImage, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	if result.Manifest, err = remoteImage.Manifest(); err != nil {
+		return nil, err
+	}
+	// Only configuration blob is downloaded
+	if result.RawConfig, err = remoteImage.RawConfigFile(); err != nil {
+		return nil, err
+	}
+	if result.ConfigFile, err = remoteImage.ConfigFile(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -14,12 +14,26 @@ import (
 func (s *State) Remove(ctx context.Context, images ...string) error {
 	keepTime := time.Now().Add(-s.config.GetMinTemporaryAge())
 	infos := make([]name.Reference, 0, len(images))
+	var repoDigests []name.Digest
 	// Resolve images
 	for _, image := range images {
 		info, err := name.ParseReference(image)
 		if err != nil {
 			return err
 		}
+		if _, ok := info.(name.Tag); ok {
+			manifest, err := s.LoadManifest(ctx, info)
+			if err != nil {
+				return err
+			}
+			if manifest != nil {
+				repoDigest, err := ManifestDigestReference(info, manifest)
+				if err != nil {
+					return err
+				}
+				repoDigests = append(repoDigests, repoDigest)
+			}
+		}
 		if err := s.cacheRemove(bucketManifest, info.Name()); err != nil && !os.IsNotExist(err) {
 			return err
 		}
@@ -35,6 +49,37 @@ func (s *State) Remove(ctx context.Context, images ...string) error {
 	if err != nil {
 		return err
 	}
+	// Implicit digest references without tags in same repository are removed with last tag
+	for _, repoDigest := range repoDigests {
+		implicit, err := s.implicitDigest(repoDigest)
+		if err != nil {
+			return err
+		}
+		if !implicit {
+			continue
+		}
+		referenced := false
+		for image, manifest := range manifests {
+			if _, ok := image.(name.Tag); !ok {
+				continue
+			}
+			if tagDigest, err := ManifestDigestReference(image, manifest); err == nil && tagDigest.Name() == repoDigest.Name() {
+				referenced = true
+				break
+			}
+		}
+		if referenced {
+			continue
+		}
+		for image := range manifests {
+			if image.Name() == repoDigest.Name() {
+				if err := s.cacheRemove(bucketManifest, image.Name()); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				delete(manifests, image)
+			}
+		}
+	}
 
 	used := map[string]struct{}{}
 	for image, manifest := range manifests {
@@ -43,16 +88,21 @@ func (s *State) Remove(ctx context.Context, images ...string) error {
 			continue
 		}
 		used[cacheFile] = struct{}{}
+		if _, ok := image.(name.Digest); ok {
+			used[s.cacheFile(bucketImplicitDigest, image.Name())] = struct{}{}
+		}
 
 		configBlob := s.blobName(manifest.Config, "")
 		if _, ok := used[configBlob]; !ok {
 			used[configBlob] = struct{}{}
+			used[s.cacheFile(bucketSource, manifest.Config.Digest.String())] = struct{}{}
 		}
 
 		for _, layer := range manifest.Layers {
 			layerBlob := s.blobName(layer, "")
 			if _, ok := used[layerBlob]; !ok {
 				used[layerBlob] = struct{}{}
+				used[s.cacheFile(bucketSource, layer.Digest.String())] = struct{}{}
 
 				unpacked, err := s.GetUnpackedLayerDescriptor(ctx, layer)
 				if err != nil {
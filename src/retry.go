@@ -0,0 +1,266 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// Upper limit for delay requested by registry with Retry-After header
+	retryMaxAfter = 5 * time.Minute
+)
+
+var retryStatusCodes = map[int]struct{}{
+	http.StatusRequestTimeout:      {},
+	http.StatusTooManyRequests:     {},
+	http.StatusInternalServerError: {},
+	http.StatusBadGateway:          {},
+	http.StatusServiceUnavailable:  {},
+	http.StatusGatewayTimeout:      {},
+}
+
+// retryTransport retries requests on transient network errors and registry responses with exponential backoff.
+type retryTransport struct {
+	inner      http.RoundTripper
+	maxRetries int
+	// Timeout for registry response of single request attempt without body, zero for no timeout
+	timeout time.Duration
+}
+
+func newRetryTransport(inner http.RoundTripper, config Config) http.RoundTripper {
+	return &retryTransport{
+		inner:      inner,
+		maxRetries: config.GetMaxRetries(),
+		timeout:    config.GetTimeout(),
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Request body can be sent again only if it can be recreated
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.roundTrip(attemptReq)
+		retry := false
+		if err != nil {
+			retry = req.Context().Err() == nil && isTransientError(err)
+		} else if _, ok := retryStatusCodes[resp.StatusCode]; ok {
+			retry = true
+		}
+		if !retry || !replayable || attempt >= t.maxRetries {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+			}
+			logrus.Warnf("retry %s %s in %s: status %d", req.Method, req.URL.Redacted(), delay, resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		} else {
+			logrus.Warnf("retry %s %s in %s: %v", req.Method, req.URL.Redacted(), delay, err)
+		}
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// roundTrip sends request, waiting for response headers is limited by timeout.
+// Requests with body (blob uploads) aren't limited: sending of large body can take any time.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 || (req.Body != nil && req.Body != http.NoBody) {
+		return t.inner.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.inner.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() && req.Context().Err() == nil {
+		// Response can't be used after request context cancellation
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("%w: no response in %s from %s", errTimeout, t.timeout, req.URL.Host)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+var errTimeout = errors.New("registry request timeout")
+
+// cancelBody releases request context on response body close.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// retryDelay returns exponential backoff delay with jitter for retry attempt.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// Jitter in range [delay/2, delay*3/2)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// retryAfter parses Retry-After header in seconds or HTTP date format.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, false
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > retryMaxAfter {
+		delay = retryMaxAfter
+	}
+	return delay, true
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withRetry repeats operation on network failures, which can't be retried by transport (like broken response body).
+func (s *State) withRetry(ctx context.Context, operation func() error) error {
+	maxRetries := s.config.GetMaxRetries()
+	for attempt := 0; ; attempt++ {
+		err := operation()
+		if err == nil || ctx.Err() != nil || attempt >= maxRetries || !isTransientError(err) {
+			return err
+		}
+		delay := retryDelay(attempt)
+		logrus.Warnf("retry in %s: %v", delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// isTransientError checks that error is caused by network failure.
+func isTransientError(err error) bool {
+	return hasCause(err, func(err error) bool {
+		var netErr net.Error
+		var opErr *net.OpError
+		return errors.Is(err, errTimeout) ||
+			errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, syscall.EPIPE) ||
+			errors.Is(err, net.ErrClosed) ||
+			errors.As(err, &opErr) ||
+			(errors.As(err, &netErr) && netErr.Timeout())
+	})
+}
+
+// hasCause checks error and its causes including errorx wrapped ones.
+func hasCause(err error, match func(err error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+		e := errorx.Cast(err)
+		if e == nil {
+			return false
+		}
+		err = e.Cause()
+	}
+	return false
+}
+
+// remoteError converts registry error to typed error: ErrAuth, ErrImageNotFound, ErrNotFound or ErrRegistryUnavailable.
+func remoteError(err error, ref string) error {
+	if err == nil {
+		return nil
+	}
+	if e := errorx.Cast(err); e != nil && (e.IsOfType(ErrAuth) || e.IsOfType(ErrImageNotFound) || e.IsOfType(ErrNotFound) || e.IsOfType(ErrRegistryUnavailable) || e.IsOfType(ErrOffline)) {
+		return err
+	}
+	var transportErr *transport.Error
+	if hasCause(err, func(err error) bool { return errors.As(err, &transportErr) }) {
+		for _, diagnostic := range transportErr.Errors {
+			switch diagnostic.Code {
+			case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+				return ErrAuth.Wrap(err, "access denied: %s", ref)
+			case transport.ManifestUnknownErrorCode:
+				return ErrImageNotFound.Wrap(err, "image not found: %s", ref)
+			case transport.BlobUnknownErrorCode, transport.NameUnknownErrorCode:
+				return ErrNotFound.Wrap(err, "not found: %s", ref)
+			}
+		}
+		switch {
+		case transportErr.StatusCode == http.StatusUnauthorized || transportErr.StatusCode == http.StatusForbidden:
+			return ErrAuth.Wrap(err, "access denied: %s", ref)
+		case transportErr.StatusCode == http.StatusNotFound && isManifestHead(transportErr.Request):
+			// Response to HEAD request has no body with error code
+			return ErrImageNotFound.Wrap(err, "image not found: %s", ref)
+		case transportErr.StatusCode == http.StatusNotFound:
+			return ErrNotFound.Wrap(err, "not found: %s", ref)
+		}
+		if _, ok := retryStatusCodes[transportErr.StatusCode]; ok {
+			return ErrRegistryUnavailable.Wrap(err, "registry unavailable: %s", ref)
+		}
+		return err
+	}
+	if isTransientError(err) {
+		return ErrRegistryUnavailable.Wrap(err, "registry unavailable: %s", ref)
+	}
+	return err
+}
+
+func isManifestHead(req *http.Request) bool {
+	return req != nil && req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/manifests/")
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"io"
-	"os"
 	"path"
 	"sort"
 
@@ -17,7 +16,6 @@ import (
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/uuid"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/joomcode/errorx"
 	"github.com/klauspost/compress/gzip"
 	"github.com/opencontainers/go-digest"
 )
@@ -43,7 +41,7 @@ func (s *State) Save(ctx context.Context, w io.Writer, images ...string) error {
 			return err
 		}
 		if manifest == nil {
-			return errorx.IllegalArgument.New("can't find manifest for tag: %s", info.Name())
+			return ErrImageNotFound.New("can't find manifest for tag: %s", info.Name())
 		}
 		manifests = append(manifests, manifest)
 
@@ -90,7 +88,7 @@ func (s *State) writeExportManifest(ctx context.Context, w *tar.Writer, tags map
 		if exportImage == nil {
 			config := configs[hash]
 			if config == nil {
-				return errorx.InternalError.New("can't find config for digest: %s", hash)
+				return ErrCacheCorrupted.New("can't find config for digest: %s", hash)
 			}
 			layers := make([]string, 0, len(config.RootFS.DiffIDs))
 			for _, layer := range config.RootFS.DiffIDs {
@@ -170,7 +168,7 @@ func (s *State) writeLayers(ctx context.Context, w *tar.Writer, manifests ...*sc
 	for _, layer := range queue {
 		unpacked, ok := layers[layer]
 		if !ok {
-			return nil, errorx.IllegalState.New("can't find unpacked layer: %s", layer)
+			return nil, ErrCacheCorrupted.New("can't find unpacked layer: %s", layer)
 		}
 		if err := s.writeLayer(ctx, w, unpacked); err != nil {
 			return nil, err
@@ -240,7 +238,7 @@ func (s *State) GetUnpackedLayerDescriptor(ctx context.Context, layer distributi
 	if found {
 		var desc distribution.Descriptor
 		if err := json.Unmarshal(cached, &desc); err == nil {
-			if stat, err := os.Stat(s.blobName(desc, "")); err == nil && !stat.IsDir() {
+			if stat, err := s.stateVfs.Stat(s.blobName(desc, "")); err == nil && !stat.IsDir() {
 				unpackedDesc = &desc
 			}
 		}
@@ -0,0 +1,175 @@
+package src
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/joomcode/errorx"
+	"github.com/sirupsen/logrus"
+)
+
+// Squash merges image layers starting from index from into single layer and saves result as target image.
+func (s *State) Squash(ctx context.Context, image name.Reference, target name.Reference, from int) (*schema2.DeserializedManifest, error) {
+	manifest, err := s.LoadManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrImageNotFound.New("image not found: %s", image.Name())
+	}
+	squashed, err := s.squashManifest(ctx, manifest, from)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveManifest(ctx, squashed, target); err != nil {
+		return nil, err
+	}
+	return squashed, nil
+}
+
+func (s *State) squashManifest(ctx context.Context, manifest *schema2.DeserializedManifest, from int) (*schema2.DeserializedManifest, error) {
+	if from < 0 || from >= len(manifest.Layers) {
+		return nil, errorx.IllegalArgument.New("layer index %d is out of range [0, %d)", from, len(manifest.Layers))
+	}
+
+	configFile, err := s.LoadConfigFile(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(configFile.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, errorx.IllegalState.New("image has %d layers, but %d diff IDs", len(manifest.Layers), len(configFile.RootFS.DiffIDs))
+	}
+
+	// Merge kept layers and all layers with same tar headers for unchanged entries
+	kept, origin, err := s.mergeLayerTrees(ctx, manifest.Layers[:from])
+	if err != nil {
+		return nil, err
+	}
+	root := kept.Clone()
+	if err := s.applyLayerTrees(ctx, root, origin, manifest.Layers[from:], from); err != nil {
+		return nil, err
+	}
+	fs := &ImageFS{
+		FS: FS{
+			Base: root,
+		},
+		Layers: manifest.Layers,
+		origin: origin,
+	}
+
+	var nodes []*TreeNode
+	var whiteouts []*tar.Header
+	if squashDiff(kept, root, &nodes, &whiteouts) {
+		// Root directory has no entry in layer
+		nodes = nodes[1:]
+	}
+
+	t := time.Now()
+	diffID, layer, err := s.writeLayerBlob(ctx, func(t *tar.Writer) error {
+		if err := s.exportNodes(ctx, t, fs, nodes); err != nil {
+			return err
+		}
+		for _, header := range whiteouts {
+			if err := t.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("squashed %d layers: %s, %v", len(manifest.Layers)-from, layer.Digest, time.Now().Sub(t))
+
+	hash, err := v1.NewHash(diffID.String())
+	if err != nil {
+		return nil, err
+	}
+	configFile.RootFS.DiffIDs = append(configFile.RootFS.DiffIDs[:from:from], hash)
+
+	// Keep history of squashed layers as empty entries
+	layerIndex := 0
+	for i := range configFile.History {
+		if configFile.History[i].EmptyLayer {
+			continue
+		}
+		if layerIndex >= from {
+			configFile.History[i].EmptyLayer = true
+		}
+		layerIndex++
+	}
+	configFile.History = append(configFile.History, v1.History{
+		Created: v1.Time{
+			Time: time.Now().UTC(),
+		},
+		CreatedBy: fmt.Sprintf("porter squash --from %d", from),
+		Comment:   fmt.Sprintf("merge %d layers", len(manifest.Layers)-from),
+	})
+
+	config, err := s.saveConfigFile(ctx, configFile, manifest.Config.Platform)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]distribution.Descriptor, 0, from+1)
+	layers = append(layers, manifest.Layers[:from]...)
+	layers = append(layers, *layer)
+	return schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    *config,
+		Layers:    layers,
+	})
+}
+
+// squashDiff collects entries of merged tree, which are changed relative to kept tree, and whiteouts for removed entries.
+// Returns true if any changes found.
+func squashDiff(kept *TreeNode, merged *TreeNode, nodes *[]*TreeNode, whiteouts *[]*tar.Header) bool {
+	changed := kept == nil || kept.Header != merged.Header
+	if merged.Typeflag != tar.TypeDir {
+		if changed {
+			*nodes = append(*nodes, merged)
+		}
+		return changed
+	}
+	if kept != nil && kept.Typeflag != tar.TypeDir {
+		kept = nil
+	}
+
+	// Reserve place for directory entry before children
+	position := len(*nodes)
+	*nodes = append(*nodes, merged)
+	hasChanges := false
+	for _, childName := range merged.Names() {
+		var keptChild *TreeNode
+		if kept != nil {
+			keptChild = kept.Child[childName]
+		}
+		if squashDiff(keptChild, merged.Child[childName], nodes, whiteouts) {
+			hasChanges = true
+		}
+	}
+	if kept != nil {
+		for _, childName := range kept.Names() {
+			if _, ok := merged.Child[childName]; !ok {
+				*whiteouts = append(*whiteouts, &tar.Header{
+					Name:     path.Join(merged.Name, archive.WhiteoutPrefix+childName),
+					Typeflag: tar.TypeReg,
+					Mode:     0644,
+				})
+				hasChanges = true
+			}
+		}
+	}
+	if !changed && !hasChanges {
+		*nodes = (*nodes)[:position]
+		return false
+	}
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/blang/vfs"
 	"github.com/blang/vfs/memfs"
 	"github.com/blang/vfs/prefixfs"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/sirupsen/logrus"
 	"github.com/tinylib/msgp/msgp"
 )
@@ -22,10 +24,27 @@ type StateConfig interface {
 	GetMemoryCache() bool
 }
 
+// OfflineConfig is optionally implemented by StateConfig to force offline mode.
+type OfflineConfig interface {
+	GetOffline() bool
+}
+
 type State struct {
 	configFile string
 	config     Config
 	stateVfs   vfs.Filesystem
+	transports map[string]http.RoundTripper
+	// Transport for registries without custom configuration
+	defaultTransport http.RoundTripper
+	credentials      CredentialStore
+	offline          bool
+}
+
+func isOffline(config StateConfig) bool {
+	if o, ok := config.(OfflineConfig); ok {
+		return o.GetOffline()
+	}
+	return false
 }
 
 func NewState(config StateConfig) (*State, error) {
@@ -53,10 +72,19 @@ func NewState(config StateConfig) (*State, error) {
 		return nil, err
 	}
 
+	transports, err := newTransports(stateConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &State{
-		configFile: configFile,
-		config:     stateConfig,
-		stateVfs:   stateVfs,
+		configFile:       configFile,
+		config:           stateConfig,
+		stateVfs:         stateVfs,
+		transports:       transports,
+		defaultTransport: newRetryTransport(remote.DefaultTransport, stateConfig),
+		credentials:      newCredentialStore(stateConfig, configFile),
+		offline:          isOffline(config) || stateConfig.Offline,
 	}, nil
 }
 
@@ -87,11 +115,11 @@ func (s *State) cacheLoad(bucket string, key string) ([]byte, bool, error) {
 	}
 	_, cached, err = msgp.ReadStringBytes(cached)
 	if err != nil {
-		return nil, false, err
+		return nil, false, ErrCacheCorrupted.Wrap(err, "can't decode cache entry %s: %s", bucket, key)
 	}
 	value, _, err := msgp.ReadBytesBytes(cached, nil)
 	if err != nil {
-		return nil, false, err
+		return nil, false, ErrCacheCorrupted.Wrap(err, "can't decode cache entry %s: %s", bucket, key)
 	}
 	return value, true, nil
 }
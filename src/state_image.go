@@ -1,10 +1,13 @@
 package src
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 
 	"github.com/docker/distribution/manifest/schema2"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
@@ -12,6 +15,7 @@ type stateImage struct {
 	ctx      context.Context
 	state    *State
 	manifest *schema2.DeserializedManifest
+	uploads  *uploadCounter
 }
 
 func (s *State) NewImage(ctx context.Context, manifest *schema2.DeserializedManifest) v1.Image {
@@ -19,31 +23,82 @@ func (s *State) NewImage(ctx context.Context, manifest *schema2.DeserializedMani
 		ctx:      ctx,
 		state:    s,
 		manifest: manifest,
+		uploads:  newUploadCounter(),
 	}
 }
 
+// Uploaded returns count of blob bytes, that was read for upload to registry.
+func (s stateImage) Uploaded() int64 {
+	return s.uploads.total()
+}
+
 func (s stateImage) Layers() ([]v1.Layer, error) {
 	layers := make([]v1.Layer, 0, len(s.manifest.Layers))
 	for _, layer := range s.manifest.Layers {
-		layers = append(layers, s.state.NewLayer(s.ctx, layer))
+		mountable, err := s.mountableLayer(&stateLayer{
+			ctx:        s.ctx,
+			state:      s.state,
+			descriptor: layer,
+			uploads:    s.uploads,
+		})
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, mountable)
 	}
 	return layers, nil
 }
 
+func (s stateImage) ConfigLayer() (v1.Layer, error) {
+	return s.mountableLayer(&stateLayer{
+		ctx:        s.ctx,
+		state:      s.state,
+		descriptor: s.manifest.Config,
+		uploads:    s.uploads,
+	})
+}
+
+// mountableLayer allows registry to mount layer from the repository it was downloaded from.
+func (s stateImage) mountableLayer(layer *stateLayer) (v1.Layer, error) {
+	source, err := s.state.LoadBlobSource(s.ctx, layer.descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return layer, nil
+	}
+	return &remote.MountableLayer{
+		Layer:     layer,
+		Reference: source.Digest(layer.descriptor.Digest.String()),
+	}, nil
+}
+
 func (s stateImage) MediaType() (types.MediaType, error) {
 	return types.MediaType(s.manifest.MediaType), nil
 }
 
 func (s stateImage) Size() (int64, error) {
-	panic("implement me")
+	rawManifest, err := s.RawManifest()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rawManifest)), nil
 }
 
 func (s stateImage) ConfigName() (v1.Hash, error) {
-	panic("implement me")
+	return v1.NewHash(s.manifest.Config.Digest.String())
 }
 
 func (s stateImage) ConfigFile() (*v1.ConfigFile, error) {
-	panic("implement me")
+	rawConfig, err := s.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	var configFile v1.ConfigFile
+	if err := json.Unmarshal(rawConfig, &configFile); err != nil {
+		return nil, err
+	}
+	return &configFile, nil
 }
 
 func (s stateImage) RawConfigFile() ([]byte, error) {
@@ -51,11 +106,20 @@ func (s stateImage) RawConfigFile() ([]byte, error) {
 }
 
 func (s stateImage) Digest() (v1.Hash, error) {
-	panic("implement me")
+	rawManifest, err := s.RawManifest()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	hash, _, err := v1.SHA256(bytes.NewReader(rawManifest))
+	return hash, err
 }
 
 func (s stateImage) Manifest() (*v1.Manifest, error) {
-	panic("implement me")
+	rawManifest, err := s.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	return v1.ParseManifest(bytes.NewReader(rawManifest))
 }
 
 func (s stateImage) RawManifest() ([]byte, error) {
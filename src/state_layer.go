@@ -3,16 +3,32 @@ package src
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/docker/distribution"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
 )
 
 type stateLayer struct {
 	ctx        context.Context
 	state      *State
 	descriptor distribution.Descriptor
+	uploads    *uploadCounter
+}
+
+// uploadCounter counts bytes read for upload of each blob. Blob can be read again on upload retry,
+// so only last attempt is counted.
+type uploadCounter struct {
+	mutex  sync.Mutex
+	counts map[digest.Digest]int64
+}
+
+type countingReader struct {
+	io.ReadCloser
+	uploads *uploadCounter
+	digest  digest.Digest
 }
 
 func (s *State) NewLayer(ctx context.Context, descriptor distribution.Descriptor) v1.Layer {
@@ -32,7 +48,16 @@ func (l stateLayer) DiffID() (v1.Hash, error) {
 }
 
 func (l stateLayer) Compressed() (io.ReadCloser, error) {
-	return l.state.OpenBlob(l.ctx, l.descriptor)
+	r, err := l.state.OpenBlob(l.ctx, l.descriptor)
+	if err != nil || l.uploads == nil {
+		return r, err
+	}
+	l.uploads.reset(l.descriptor.Digest)
+	return &countingReader{
+		ReadCloser: r,
+		uploads:    l.uploads,
+		digest:     l.descriptor.Digest,
+	}, nil
 }
 
 func (l stateLayer) Uncompressed() (io.ReadCloser, error) {
@@ -46,3 +71,37 @@ func (l stateLayer) Size() (int64, error) {
 func (l stateLayer) MediaType() (types.MediaType, error) {
 	return types.MediaType(l.descriptor.MediaType), nil
 }
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.uploads.add(r.digest, int64(n))
+	return n, err
+}
+
+func newUploadCounter() *uploadCounter {
+	return &uploadCounter{
+		counts: make(map[digest.Digest]int64),
+	}
+}
+
+func (c *uploadCounter) reset(blob digest.Digest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[blob] = 0
+}
+
+func (c *uploadCounter) add(blob digest.Digest, n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[blob] += n
+}
+
+func (c *uploadCounter) total() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var result int64
+	for _, count := range c.counts {
+		result += count
+	}
+	return result
+}
@@ -3,8 +3,6 @@ package src
 import (
 	"context"
 	"github.com/google/go-containerregistry/pkg/name"
-
-	"github.com/joomcode/errorx"
 )
 
 func (s *State) Tag(ctx context.Context, source string, target string) error {
@@ -22,7 +20,7 @@ func (s *State) Tag(ctx context.Context, source string, target string) error {
 		return err
 	}
 	if manifest == nil {
-		return errorx.IllegalArgument.New("can't find manifest for tag: %s", sourceInfo.Name())
+		return ErrImageNotFound.New("can't find manifest for tag: %s", sourceInfo.Name())
 	}
 
 	if err := s.SaveManifest(ctx, manifest, targetInfo); err != nil {
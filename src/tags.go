@@ -0,0 +1,90 @@
+package src
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/joomcode/errorx"
+	"golang.org/x/mod/semver"
+)
+
+// ListTags returns repository tags matching glob pattern (all tags for empty pattern).
+// Tags are sorted by semantic version in descending order, non-version tags go last in alphabetical order.
+// Result is truncated to limit tags if limit is positive.
+func (s *State) ListTags(ctx context.Context, repo name.Repository, pattern string, limit int) ([]string, error) {
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "invalid tag pattern: %s", pattern)
+		}
+	}
+
+	var tags []string
+	if err := s.withMirrors(repo.Tag("latest"), func(ref name.Reference) error {
+		var err error
+		tags, err = remote.List(ref.Context(), append(s.RemoveOptions(ref), remote.WithContext(ctx))...)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, tag); !matched {
+				continue
+			}
+		}
+		result = append(result, tag)
+	}
+	sortTags(result)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// Catalog returns sorted repositories of registry.
+func (s *State) Catalog(ctx context.Context, registry name.Registry) ([]string, error) {
+	if err := s.checkOnline(registry.Name()); err != nil {
+		return nil, err
+	}
+	repos, err := remote.Catalog(ctx, registry, s.registryOptions(registry)...)
+	if err != nil {
+		return nil, remoteError(err, registry.Name())
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// sortTags sorts tags by semantic version in descending order, non-version tags go last.
+func sortTags(tags []string) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tagVersion(tags[i]), tagVersion(tags[j])
+		if a == "" || b == "" {
+			if a != b {
+				return b == ""
+			}
+			return tags[i] < tags[j]
+		}
+		if c := semver.Compare(a, b); c != 0 {
+			return c > 0
+		}
+		return tags[i] < tags[j]
+	})
+}
+
+// tagVersion returns semantic version for tag in "v" prefixed form or empty string if tag is not a version.
+func tagVersion(tag string) string {
+	version := tag
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !semver.IsValid(version) {
+		return ""
+	}
+	return version
+}
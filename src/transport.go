@@ -0,0 +1,54 @@
+package src
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/joomcode/errorx"
+)
+
+// newTransports creates HTTP transports for all registries with custom configuration.
+func newTransports(config Config) (map[string]http.RoundTripper, error) {
+	transports := make(map[string]http.RoundTripper)
+	for registry, registryConfig := range config.Registries {
+		transport, err := newRegistryTransport(registryConfig)
+		if err != nil {
+			return nil, errorx.Decorate(err, "can't create transport for registry: %s", registry)
+		}
+		if normalized, err := name.NewRegistry(registry); err == nil {
+			registry = normalized.RegistryStr()
+		}
+		transports[registry] = newRetryTransport(transport, config)
+	}
+	return transports, nil
+}
+
+func newRegistryTransport(config RegistryConfig) (http.RoundTripper, error) {
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	if config.Insecure || config.CA != "" {
+		tlsConfig := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: config.Insecure,
+		}
+		if config.CA != "" {
+			pem, err := os.ReadFile(config.CA)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errorx.IllegalFormat.New("can't find certificates in CA bundle: %s", config.CA)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
@@ -43,3 +43,12 @@ func safeWrite(fs vfs.Filesystem, filename string, task func(w io.Writer) error)
 		return nil
 	}
 }
+
+// storeBlob moves temporary file to blob file. Blob name is content digest, so existing blob is kept as is.
+func storeBlob(fs vfs.Filesystem, tempFile string, target string) error {
+	_ = vfs.MkdirAll(fs, path.Dir(target), 0755)
+	if _, err := fs.Stat(target); err == nil {
+		return nil
+	}
+	return fs.Rename(tempFile, target)
+}
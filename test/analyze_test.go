@@ -0,0 +1,70 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeWasted(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestImage(t, tag, map[string][]byte{
+		"a.txt":     []byte("removed"),
+		"dir/b.txt": []byte("old"),
+		"dir/c.txt": []byte("kept"),
+	}, map[string][]byte{
+		".wh.a.txt": nil,
+		"dir/b.txt": []byte("newer"),
+	})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	report, err := state.Analyze(ctx, image, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(19), report.TotalSize)
+	assert.Equal(t, int64(10), report.WastedSize)
+	assert.Equal(t, []src.AnalyzeWasted{
+		{Path: "/a.txt", Size: 7, Layer: 0, By: 1, Deleted: true},
+		{Path: "/dir/b.txt", Size: 3, Layer: 0, By: 1},
+	}, report.Wasted)
+	assert.Equal(t, []src.AnalyzeDuplicate{
+		{Path: "/dir/b.txt", Layers: []int{0, 1}, TotalSize: 8},
+	}, report.Duplicates)
+	assert.Equal(t, []src.AnalyzeDirectory{
+		{Path: "/dir", Files: 2, Size: 9},
+	}, report.Directories)
+}
+
+func TestAnalyzeCheckWasted(t *testing.T) {
+	report := &src.AnalyzeReport{
+		TotalSize:  1000,
+		WastedSize: 100,
+	}
+	assert.NoError(t, report.CheckWasted("100B"))
+	assert.NoError(t, report.CheckWasted("10%"))
+
+	err := report.CheckWasted("99B")
+	assert.True(t, errorx.IsOfType(err, src.ErrWastedLimit), "unexpected error: %v", err)
+	err = report.CheckWasted("9.9%")
+	assert.True(t, errorx.IsOfType(err, src.ErrWastedLimit), "unexpected error: %v", err)
+	assert.Equal(t, 40, src.ExitCode(err))
+
+	err = report.CheckWasted("lots")
+	assert.True(t, errorx.IsOfType(err, errorx.IllegalArgument), "unexpected error: %v", err)
+	err = report.CheckWasted("x%")
+	assert.True(t, errorx.IsOfType(err, errorx.IllegalArgument), "unexpected error: %v", err)
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+
+	// Gzipped tarball must be stored as is
+	var tarball bytes.Buffer
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "layer.txt", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("layer"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	tarballFile := path.Join(dir, "layer.tar.gz")
+	require.NoError(t, os.WriteFile(tarballFile, tarball.Bytes(), 0644))
+
+	require.NoError(t, os.MkdirAll(path.Join(dir, "files", "sub"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "files", "sub", "file.txt"), []byte("file"), 0644))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	target, err := name.ParseReference("local/append:latest")
+	require.NoError(t, err)
+	manifest, err := state.Append(ctx, "scratch", []string{tarballFile}, []src.AppendDir{
+		{Source: path.Join(dir, "files"), Dest: "/opt"},
+	}, target)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 2)
+	assert.Equal(t, int64(tarball.Len()), manifest.Layers[0].Size)
+
+	configFile, err := state.LoadConfigFile(ctx, manifest)
+	require.NoError(t, err)
+	assert.Len(t, configFile.RootFS.DiffIDs, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, state.Export(ctx, target, &buf))
+	assert.Equal(t, map[string]string{
+		"layer.txt":        "layer",
+		"opt/":             "",
+		"opt/sub/":         "",
+		"opt/sub/file.txt": "file",
+	}, readTar(t, &buf))
+}
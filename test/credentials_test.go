@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginEncryptedCredentials(t *testing.T) {
+	t.Setenv(src.CredentialsKeyEnv, "secret-key")
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "go-porter.yaml")
+
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, state.Login(ctx, "joomcode", "PasswOrd", "registry.example.com"))
+
+	data, err := os.ReadFile(path.Join(path.Dir(config.ConfigFile), "go-porter.credentials.yaml"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "PasswOrd")
+
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+
+	registry, err := name.NewRegistry("registry.example.com")
+	require.NoError(t, err)
+	auth, err := state.Keychain().Resolve(registry)
+	require.NoError(t, err)
+	authConfig, err := auth.Authorization()
+	require.NoError(t, err)
+	assert.Equal(t, "joomcode", authConfig.Username)
+	assert.Equal(t, "PasswOrd", authConfig.Password)
+
+	require.NoError(t, state.Logout(ctx, "registry.example.com"))
+	assert.Error(t, state.Logout(ctx, "registry.example.com"))
+}
+
+func TestCredentialsCached(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(src.CredentialsKeyEnv, "secret-key")
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "go-porter.yaml")
+	credentialsFile := path.Join(path.Dir(config.ConfigFile), "go-porter.credentials.yaml")
+
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, state.Login(ctx, "joomcode", "PasswOrd", "registry.example.com"))
+
+	resolve := func(host string) string {
+		registry, err := name.NewRegistry(host)
+		require.NoError(t, err)
+		auth, err := state.Keychain().Resolve(registry)
+		require.NoError(t, err)
+		authConfig, err := auth.Authorization()
+		require.NoError(t, err)
+		return authConfig.Username
+	}
+	assert.Equal(t, "joomcode", resolve("registry.example.com"))
+
+	// Decrypted credentials are not read again
+	require.NoError(t, os.Remove(credentialsFile))
+	assert.Equal(t, "joomcode", resolve("registry.example.com"))
+
+	// Saving credentials invalidates cache
+	require.NoError(t, state.Login(ctx, "other", "PasswOrd", "other.example.com"))
+	assert.Equal(t, "joomcode", resolve("registry.example.com"))
+	assert.Equal(t, "other", resolve("other.example.com"))
+	data, err := os.ReadFile(credentialsFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "PasswOrd")
+
+	require.NoError(t, os.Remove(credentialsFile))
+	require.NoError(t, state.Logout(ctx, "other.example.com"))
+	assert.Equal(t, "joomcode", resolve("registry.example.com"))
+	assert.Equal(t, "", resolve("other.example.com"))
+}
+
+func TestKeychainOrder(t *testing.T) {
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	require.NoError(t, os.WriteFile(path.Join(dockerConfig, "config.json"), []byte(`{
+  "auths": {
+    "porter.example.com": {"auth": "ZG9ja2VyOmRvY2tlci1wYXNz"},
+    "docker.example.com": {"auth": "ZG9ja2VyOmRvY2tlci1wYXNz"}
+  }
+}`), 0644))
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "go-porter.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+auths:
+  "porter.example.com":
+    username: porter
+    password: porter-pass
+`), 0644))
+
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	for host, username := range map[string]string{
+		// Porter configuration overrides docker configuration
+		"porter.example.com": "porter",
+		// Docker configuration is used when porter has no credentials
+		"docker.example.com": "docker",
+		// Anonymous access otherwise
+		"anonymous.example.com": "",
+	} {
+		registry, err := name.NewRegistry(host)
+		require.NoError(t, err)
+		auth, err := state.Keychain().Resolve(registry)
+		require.NoError(t, err)
+		authConfig, err := auth.Authorization()
+		require.NoError(t, err)
+		assert.Equal(t, username, authConfig.Username, host)
+	}
+}
+
+func TestKeychainBrokenCredentials(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(src.CredentialsKeyEnv, "secret-key")
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "go-porter.yaml")
+
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+	require.NoError(t, state.Login(context.Background(), "joomcode", "PasswOrd", "registry.example.com"))
+
+	registry, err := name.NewRegistry("registry.example.com")
+	require.NoError(t, err)
+
+	// Encrypted credentials without key
+	t.Setenv(src.CredentialsKeyEnv, "")
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+	auth, err := state.Keychain().Resolve(registry)
+	require.NoError(t, err)
+	assert.Equal(t, authn.Anonymous, auth)
+
+	// Missing credential helper
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte("credentialsstore: porter-missing-helper\n"), 0644))
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+	auth, err = state.Keychain().Resolve(registry)
+	require.NoError(t, err)
+	assert.Equal(t, authn.Anonymous, auth)
+}
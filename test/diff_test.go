@@ -0,0 +1,60 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	host := newTestRegistry(t)
+	tagA := host + "/foo:a"
+	tagB := host + "/foo:b"
+	pushTestImage(t, tagA, map[string][]byte{
+		"removed.txt": []byte("removed"),
+		"dir/b.txt":   []byte("old"),
+		"dir/c.txt":   []byte("same"),
+		"size.txt":    []byte("short"),
+	})
+	pushTestImage(t, tagB, map[string][]byte{
+		"added.txt": []byte("added"),
+		"dir/b.txt": []byte("new"),
+		"dir/c.txt": []byte("same"),
+		"size.txt":  []byte("longer"),
+	})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	imageA, err := name.ParseReference(tagA)
+	require.NoError(t, err)
+	imageB, err := name.ParseReference(tagB)
+	require.NoError(t, err)
+	for _, image := range []name.Reference{imageA, imageB} {
+		_, err = state.Pull(ctx, image, false)
+		require.NoError(t, err)
+	}
+
+	entries, err := state.Diff(ctx, imageA, imageB, "", true)
+	require.NoError(t, err)
+
+	changes := map[string][]string{}
+	for _, entry := range entries {
+		changes[entry.Path] = append([]string{string(entry.Kind)}, entry.Changes...)
+	}
+	assert.Equal(t, map[string][]string{
+		"/added.txt":   {"added"},
+		"/dir/b.txt":   {"modified", "content"},
+		"/removed.txt": {"removed"},
+		"/size.txt":    {"modified", "size"},
+	}, changes)
+
+	entries, err = state.Diff(ctx, imageA, imageB, "/dir", false)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, item := range []struct {
+		errorType *errorx.Type
+		code      int
+	}{
+		{src.ErrImageNotFound, 10},
+		{src.ErrAuth, 11},
+		{src.ErrRegistryUnavailable, 12},
+		{src.ErrOffline, 13},
+		{src.ErrNotFound, 14},
+		{src.ErrDockerfileParse, 20},
+		{src.ErrUnsupportedInstruction, 21},
+		{src.ErrCacheCorrupted, 30},
+		{src.ErrDigestMismatch, 31},
+		{src.ErrWastedLimit, 40},
+		{errorx.IllegalArgument, 1},
+	} {
+		err := item.errorType.New("failed")
+		assert.Equal(t, item.code, src.ExitCode(err), item.errorType.FullName())
+		// Decorated error keeps type
+		assert.Equal(t, item.code, src.ExitCode(errorx.Decorate(err, "decorated")), item.errorType.FullName())
+	}
+	assert.Equal(t, 1, src.ExitCode(errors.New("failed")))
+}
+
+func TestWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	err := src.ErrImageNotFound.New("image not found: foo:latest")
+	assert.Equal(t, 10, src.WriteError(&buf, err, "json"))
+
+	var output map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &output))
+	assert.Equal(t, map[string]interface{}{
+		"error":    "porter.image_not_found: image not found: foo:latest",
+		"type":     "porter.image_not_found",
+		"exitCode": float64(10),
+	}, output)
+
+	// Untyped error has no type field
+	buf.Reset()
+	assert.Equal(t, 1, src.WriteError(&buf, errors.New("failed"), "json"))
+	assert.JSONEq(t, `{"error":"failed","exitCode":1}`, buf.String())
+
+	buf.Reset()
+	assert.Equal(t, 11, src.WriteError(&buf, src.ErrAuth.New("access denied"), ""))
+	assert.Equal(t, "porter.auth: access denied\n", buf.String())
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportWhiteout(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestImage(t, tag, map[string][]byte{
+		"a.txt":     []byte("removed"),
+		"dir/b.txt": []byte("old"),
+	}, map[string][]byte{
+		".wh.a.txt": nil,
+		"dir/b.txt": []byte("new"),
+	})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, state.Export(ctx, image, &buf))
+
+	assert.Equal(t, map[string]string{
+		"dir/":      "",
+		"dir/b.txt": "new",
+	}, readTar(t, &buf))
+}
+
+func TestCopyFromImage(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestLayers(t, tag, tarLayer(t,
+		tarEntry{Header: tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{Header: tar.Header{Name: "app/a.txt", Typeflag: tar.TypeReg, Mode: 0644}, Data: "old"},
+		tarEntry{Header: tar.Header{Name: "app/removed.txt", Typeflag: tar.TypeReg, Mode: 0644}, Data: "removed"},
+		tarEntry{Header: tar.Header{Name: "app/run.sh", Typeflag: tar.TypeReg, Mode: 0750}, Data: "#!/bin/sh\n"},
+		tarEntry{Header: tar.Header{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"}},
+	), tarLayer(t,
+		tarEntry{Header: tar.Header{Name: "app/.wh.removed.txt", Typeflag: tar.TypeReg, Mode: 0644}},
+		tarEntry{Header: tar.Header{Name: "app/a.txt", Typeflag: tar.TypeReg, Mode: 0644}, Data: "new"},
+	))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	// Directory is copied into existing directory
+	dest := t.TempDir()
+	require.NoError(t, state.CopyFromImage(ctx, image, "/app", dest))
+	data, err := os.ReadFile(path.Join(dest, "app/a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	_, err = os.Lstat(path.Join(dest, "app/removed.txt"))
+	assert.True(t, os.IsNotExist(err))
+	stat, err := os.Stat(path.Join(dest, "app/run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), stat.Mode().Perm())
+	link, err := os.Readlink(path.Join(dest, "app/link"))
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", link)
+
+	// Single file is copied to new name
+	target := path.Join(t.TempDir(), "run")
+	require.NoError(t, state.CopyFromImage(ctx, image, "app/run.sh", target))
+	data, err = os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\n", string(data))
+
+	// Whited-out file is absent in image
+	assert.Error(t, state.CopyFromImage(ctx, image, "/app/removed.txt", t.TempDir()))
+}
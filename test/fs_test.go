@@ -0,0 +1,116 @@
+package test
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalSymlinks(t *testing.T) {
+	root := (&src.State{}).EmptyLayer()
+	root.Add(&tar.Header{Name: "usr/bin/busybox", Typeflag: tar.TypeReg, Mode: 0755})
+	root.Add(&tar.Header{Name: "usr/bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"})
+	root.Add(&tar.Header{Name: "./bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"})
+	root.Add(&tar.Header{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "/loop"})
+
+	fs := src.FS{Base: root}
+
+	resolved, err := fs.EvalSymlinks("/bin/sh")
+	require.NoError(t, err)
+	assert.Equal(t, "usr/bin/busybox", resolved)
+
+	_, err = fs.EvalSymlinks("/loop/foo")
+	assert.Error(t, err)
+}
+
+func TestImageFSLookup(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestLayers(t, tag, tarLayer(t,
+		tarEntry{Header: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{Header: tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0644}, Data: "a"},
+		tarEntry{Header: tar.Header{Name: "dir/b.txt", Typeflag: tar.TypeReg, Mode: 0644}, Data: "old"},
+		tarEntry{Header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir"}},
+	), tarLayer(t,
+		tarEntry{Header: tar.Header{Name: "dir/b.txt", Typeflag: tar.TypeReg, Mode: 0600}, Data: "new"},
+	))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	fs, err := state.ImageFS(ctx, image)
+	require.NoError(t, err)
+
+	// Relative symlink is followed
+	target, node, err := fs.Lookup("/link/a.txt", true)
+	require.NoError(t, err)
+	assert.Equal(t, "/dir/a.txt", target)
+	layer, ok := fs.Layer(node)
+	require.True(t, ok)
+	assert.Equal(t, 0, layer)
+
+	// Changed file belongs to last layer
+	_, node, err = fs.Lookup("dir/b.txt", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0600), node.Mode)
+	layer, ok = fs.Layer(node)
+	require.True(t, ok)
+	assert.Equal(t, 1, layer)
+
+	target, node, err = fs.Lookup("/link", false)
+	require.NoError(t, err)
+	assert.Equal(t, "/link", target)
+	assert.Equal(t, byte(tar.TypeSymlink), node.Typeflag)
+
+	_, _, err = fs.Lookup("/missing", false)
+	assert.Error(t, err)
+}
+
+func TestBuildCopyRelativeSymlink(t *testing.T) {
+	host := newTestRegistry(t)
+	base := host + "/base:latest"
+	pushTestLayers(t, base, tarLayer(t,
+		tarEntry{Header: tar.Header{Name: "opt/app/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{Header: tar.Header{Name: "app", Typeflag: tar.TypeSymlink, Linkname: "opt/app"}},
+	))
+
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM "+base+"\nCOPY a.txt /app/\n"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "a.txt"), []byte("a"), 0644))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:latest"}, contextDir)
+	require.NoError(t, err)
+
+	image, err := name.ParseReference("local/foo:latest")
+	require.NoError(t, err)
+	fs, err := state.ImageFS(ctx, image)
+	require.NoError(t, err)
+
+	// File is copied through symlink, symlink itself is kept
+	_, node, err := fs.Lookup("/opt/app/a.txt", false)
+	require.NoError(t, err)
+	layer, ok := fs.Layer(node)
+	require.True(t, ok)
+	assert.Equal(t, 1, layer)
+
+	_, node, err = fs.Lookup("/app", false)
+	require.NoError(t, err)
+	assert.Equal(t, byte(tar.TypeSymlink), node.Typeflag)
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListImagesFilter(t *testing.T) {
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:1", map[string][]byte{"a.txt": []byte("a")})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(host + "/foo:1")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+	labeled, err := name.ParseReference(host + "/bar:2")
+	require.NoError(t, err)
+	_, err = state.Mutate(ctx, image, labeled, src.MutateOptions{
+		Labels: map[string]string{"team": "core"},
+	})
+	require.NoError(t, err)
+
+	list := func(filters ...string) []string {
+		images, err := state.ListImages(ctx, filters...)
+		require.NoError(t, err)
+		var result []string
+		for _, image := range images {
+			result = append(result, image.Repository+":"+image.Tag)
+		}
+		return result
+	}
+	assert.Equal(t, []string{host + "/bar:2", host + "/foo:1"}, list("reference="+host+"/*"))
+	assert.Equal(t, []string{host + "/foo:1"}, list("reference="+host+"/foo:*"))
+	assert.Equal(t, []string{host + "/bar:2"}, list("reference="+host+"/*", "label=team=core"))
+	assert.Empty(t, list("reference="+host+"/*", "label=team=other"))
+	assert.Empty(t, list("reference="+host+"/*", "dangling=true"))
+
+	_, err = state.ListImages(ctx, "unknown=value")
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectImage(t *testing.T) {
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	buildTestImage(t, state, "local/base:latest")
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM local/base:latest\nCOPY world.txt /\n"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "world.txt"), []byte("world"), 0644))
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:latest"}, contextDir)
+	require.NoError(t, err)
+	require.NoError(t, state.Tag(ctx, "local/foo:latest", "local/foo:alias"))
+	image, err := name.ParseReference("local/foo:latest")
+	require.NoError(t, err)
+
+	base, err := name.ParseReference("local/base:latest")
+	require.NoError(t, err)
+	baseInspect, err := state.InspectImage(ctx, base)
+	require.NoError(t, err)
+	assert.Empty(t, baseInspect.Parent)
+
+	inspect, err := state.InspectImage(ctx, image)
+	require.NoError(t, err)
+	manifest, err := state.LoadManifest(ctx, image)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Config.Digest.String(), inspect.ID)
+	assert.Equal(t, baseInspect.ID, inspect.Parent)
+	assert.Equal(t, []string{"local/foo:alias", "local/foo:latest"}, inspect.RepoTags)
+	assert.False(t, inspect.Metadata.LastTagTime.IsZero())
+	assert.Len(t, inspect.RootFS.Layers, 2)
+
+	// Size is uncompressed
+	var size int64
+	for _, layer := range manifest.Layers {
+		uncompressed, err := state.UncompressedSize(ctx, layer)
+		require.NoError(t, err)
+		assert.NotEqual(t, layer.Size, uncompressed)
+		size += uncompressed
+	}
+	assert.Equal(t, size, inspect.Size)
+
+	// Docker field names
+	payload, err := json.Marshal(inspect)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &fields))
+	assert.Equal(t, inspect.ID, fields["Id"])
+	assert.Contains(t, fields, "Metadata")
+
+	rawManifest, err := state.InspectRaw(ctx, image, "manifest")
+	require.NoError(t, err)
+	expected, err := state.LoadRawManifest(ctx, image)
+	require.NoError(t, err)
+	assert.Equal(t, expected, rawManifest)
+
+	rawConfig, err := state.InspectRaw(ctx, image, "config")
+	require.NoError(t, err)
+	expected, err = state.ReadBlob(ctx, manifest.Config)
+	require.NoError(t, err)
+	assert.Equal(t, expected, rawConfig)
+
+	raw, err := state.InspectRaw(ctx, image, "raw")
+	require.NoError(t, err)
+	var both map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(raw, &both))
+	assert.JSONEq(t, string(rawConfig), string(both["config"]))
+
+	_, err = state.InspectRaw(ctx, image, "unknown")
+	assert.Error(t, err)
+}
@@ -2,9 +2,14 @@ package test
 
 import (
 	"context"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/errorx"
 	"github.com/joomcode/go-porter/src"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
 	"testing"
 )
 
@@ -13,6 +18,7 @@ type TestStateConfig struct {
 	CacheDir    string
 	ConfigFile  string
 	MemoryCache bool
+	Offline     bool
 }
 
 var defaultConfig = TestStateConfig{
@@ -38,10 +44,36 @@ func (c TestStateConfig) GetMemoryCache() bool {
 	return c.MemoryCache
 }
 
+func (c TestStateConfig) GetOffline() bool {
+	return c.Offline
+}
+
+// minimalStateConfig implements only required StateConfig methods.
+type minimalStateConfig struct{}
+
+func (c minimalStateConfig) GetLogLevel() logrus.Level {
+	return logrus.InfoLevel
+}
+
+func (c minimalStateConfig) GetCacheDir() string {
+	return ""
+}
+
+func (c minimalStateConfig) GetConfigFile() string {
+	return ""
+}
+
+func (c minimalStateConfig) GetMemoryCache() bool {
+	return true
+}
+
 type TestBuildArgs struct {
 	Dockerfile string
 	Target     string
 	Tag        string
+	Squash     bool
+	Pin        bool
+	Pull       bool
 }
 
 func (t TestBuildArgs) GetDockerfile() string {
@@ -60,6 +92,18 @@ func (t TestBuildArgs) GetPlatform() string {
 	return ""
 }
 
+func (t TestBuildArgs) GetPull() bool {
+	return t.Pull
+}
+
+func (t TestBuildArgs) GetPin() bool {
+	return t.Pin
+}
+
+func (t TestBuildArgs) GetSquash() bool {
+	return t.Squash
+}
+
 func TestBuildEmpty(t *testing.T) {
 	state, err := src.NewState(defaultConfig)
 	assert.NoError(t, err)
@@ -69,3 +113,75 @@ func TestBuildEmpty(t *testing.T) {
 	_, err = state.Build(ctx, TestBuildArgs{}, "")
 	assert.EqualError(t, err, "open Dockerfile: no such file or directory")
 }
+
+func TestBuildErrorTypes(t *testing.T) {
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	for dockerfile, errorType := range map[string]*errorx.Type{
+		"FRM scratch\n":                     src.ErrDockerfileParse,
+		"COPY a /\n":                        src.ErrDockerfileParse,
+		"FROM scratch\nCOPY --from=a / /\n": src.ErrUnsupportedInstruction,
+	} {
+		contextDir := t.TempDir()
+		require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte(dockerfile), 0644))
+		_, err = state.Build(ctx, TestBuildArgs{}, contextDir)
+		assert.True(t, errorx.IsOfType(err, errorType), "unexpected error for %q: %v", dockerfile, err)
+	}
+
+	image, err := name.ParseReference("local/missing:latest")
+	require.NoError(t, err)
+	_, err = state.History(ctx, image)
+	assert.True(t, errorx.IsOfType(err, src.ErrImageNotFound), "unexpected error: %v", err)
+}
+
+func TestBuildHistory(t *testing.T) {
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM scratch\nCOPY hello.txt /\nENV GREETING=hello\nENTRYPOINT [\"/hello\"]\n"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "hello.txt"), []byte("Hello, world!!!\n"), 0644))
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/history:latest"}, contextDir)
+	require.NoError(t, err)
+
+	image, err := name.ParseReference("local/history:latest")
+	require.NoError(t, err)
+	manifest, err := state.LoadManifest(ctx, image)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 1)
+
+	history, err := state.History(ctx, image)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	// Newest first, layer belongs to COPY instruction
+	assert.Contains(t, history[0].CreatedBy, "ENTRYPOINT")
+	assert.True(t, history[0].EmptyLayer)
+	assert.Contains(t, history[1].CreatedBy, "ENV GREETING=hello")
+	assert.True(t, history[1].EmptyLayer)
+	assert.Contains(t, history[2].CreatedBy, "COPY hello.txt /")
+	assert.False(t, history[2].EmptyLayer)
+	assert.Equal(t, manifest.Layers[0].Digest.String(), history[2].Digest)
+	configFile, err := state.LoadConfigFile(ctx, manifest)
+	require.NoError(t, err)
+	assert.Equal(t, configFile.RootFS.DiffIDs[0].String(), history[2].DiffID)
+}
+
+func TestStateConfigWithoutOffline(t *testing.T) {
+	var config src.StateConfig = minimalStateConfig{}
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	// Registry access isn't blocked without offline option
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+	repo, err := name.NewRepository(host + "/foo")
+	require.NoError(t, err)
+	tags, err := state.ListTags(context.Background(), repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, tags)
+}
@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutate(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestImage(t, tag, map[string][]byte{"a.txt": []byte("a")})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	manifest, err := state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	target, err := name.ParseReference(host + "/foo:mutated")
+	require.NoError(t, err)
+	entrypoint := `["/app", "--serve"]`
+	workdir := "/srv"
+	mutated, err := state.Mutate(ctx, image, target, src.MutateOptions{
+		Labels:       map[string]string{"team": "core"},
+		Env:          []string{"A=1", "A=2"},
+		Entrypoint:   &entrypoint,
+		WorkingDir:   &workdir,
+		ExposedPorts: []string{"8080"},
+		Annotations:  map[string]string{"org.example": "value"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Layers, mutated.Layers)
+	assert.Equal(t, map[string]string{"org.example": "value"}, mutated.Config.Annotations)
+
+	configFile, err := state.LoadConfigFile(ctx, mutated)
+	require.NoError(t, err)
+	config := configFile.Config
+	assert.Equal(t, map[string]string{"team": "core"}, config.Labels)
+	assert.Equal(t, []string{"A=2"}, config.Env)
+	assert.Equal(t, []string{"/app", "--serve"}, config.Entrypoint)
+	assert.Equal(t, "/srv", config.WorkingDir)
+	assert.Equal(t, map[string]struct{}{"8080/tcp": {}}, config.ExposedPorts)
+}
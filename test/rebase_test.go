@@ -0,0 +1,74 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebase(t *testing.T) {
+	host := newTestRegistry(t)
+	oldBase := map[string][]byte{"base.txt": []byte("old")}
+	newBase := map[string][]byte{"base.txt": []byte("new")}
+	app := map[string][]byte{"app.txt": []byte("app")}
+	pushTestImage(t, host+"/base:old", oldBase)
+	pushTestImage(t, host+"/base:new", newBase)
+	pushTestImage(t, host+"/app:latest", oldBase, app)
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	refs := map[string]name.Reference{}
+	for _, tag := range []string{"base:old", "base:new", "app:latest", "app:rebased"} {
+		ref, err := name.ParseReference(host + "/" + tag)
+		require.NoError(t, err)
+		refs[tag] = ref
+	}
+	_, err = state.Pull(ctx, refs["app:latest"], false)
+	require.NoError(t, err)
+
+	manifest, err := state.Rebase(ctx, refs["app:latest"], refs["base:old"], refs["base:new"], refs["app:rebased"])
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, state.Export(ctx, refs["app:rebased"], &buf))
+	assert.Equal(t, map[string]string{
+		"app.txt":  "app",
+		"base.txt": "new",
+	}, readTar(t, &buf))
+
+	// Image must be based on old base
+	_, err = state.Rebase(ctx, refs["app:rebased"], refs["base:old"], refs["base:new"], refs["app:rebased"])
+	assert.Error(t, err)
+
+	// Same base layers with rewritten base history
+	baseLayer, err := crane.Layer(oldBase)
+	require.NoError(t, err)
+	appLayer, err := crane.Layer(app)
+	require.NoError(t, err)
+	rewritten, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: baseLayer, History: v1.History{CreatedBy: "porter squash --from 0"}},
+		mutate.Addendum{Layer: appLayer, History: v1.History{CreatedBy: "COPY app.txt /"}},
+	)
+	require.NoError(t, err)
+	rewrittenRef, err := name.ParseReference(host + "/app:rewritten")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(rewrittenRef, rewritten))
+	_, err = state.Pull(ctx, rewrittenRef, false)
+	require.NoError(t, err)
+	_, err = state.Rebase(ctx, rewrittenRef, refs["base:old"], refs["base:new"], refs["app:rebased"])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "history entry #0 differs")
+}
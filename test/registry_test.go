@@ -0,0 +1,1000 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/joomcode/errorx"
+	"github.com/joomcode/go-porter/src"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) string {
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, ref, ok := strings.Cut(r.URL.Path, "/manifests/")
+		if !ok || r.Method != http.MethodDelete {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		// Reference distribution registry doesn't support tag deletion and removes all tags with manifest
+		if !strings.HasPrefix(ref, "sha256:") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"UNSUPPORTED","message":"The operation is unsupported."}]}`))
+			return
+		}
+		list := httptest.NewRecorder()
+		handler.ServeHTTP(list, httptest.NewRequest(http.MethodGet, repo+"/tags/list", nil))
+		handler.ServeHTTP(w, r)
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		_ = json.Unmarshal(list.Body.Bytes(), &tags)
+		for _, tag := range tags.Tags {
+			head := httptest.NewRecorder()
+			handler.ServeHTTP(head, httptest.NewRequest(http.MethodHead, repo+"/manifests/"+tag, nil))
+			if head.Header().Get("Docker-Content-Digest") == ref {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, repo+"/manifests/"+tag, nil))
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return u.Host
+}
+
+// statusRecorder keeps response status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// newMountRegistry creates registry, which keeps blobs per repository and supports cross-repository blob mount.
+func newMountRegistry(t *testing.T) (string, *atomic.Int32) {
+	handler := registry.New()
+	var mutex sync.Mutex
+	blobs := make(map[string]bool)
+	mounts := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/blobs/")
+		if !ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.Method == http.MethodHead || r.Method == http.MethodGet:
+			if !blobs[repo+"@"+rest] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		case r.Method == http.MethodPost && r.URL.Query().Get("mount") != "":
+			mount := r.URL.Query().Get("mount")
+			if blobs[r.URL.Query().Get("from")+"@"+mount] {
+				blobs[repo+"@"+mount] = true
+				mounts.Add(1)
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		case r.Method == http.MethodPut:
+			recorder := &statusRecorder{ResponseWriter: w}
+			handler.ServeHTTP(recorder, r)
+			if recorder.status == http.StatusCreated {
+				blobs[repo+"@"+r.URL.Query().Get("digest")] = true
+			}
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return u.Host, mounts
+}
+
+func buildTestImage(t *testing.T, state *src.State, tag string) {
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM scratch\nCOPY hello.txt /\n"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "hello.txt"), []byte("Hello, world!!!\n"), 0644))
+
+	_, err := state.Build(context.Background(), TestBuildArgs{Tag: tag}, contextDir)
+	require.NoError(t, err)
+}
+
+func pushTestImage(t *testing.T, tag string, layers ...map[string][]byte) {
+	result := make([]v1.Layer, 0, len(layers))
+	for _, files := range layers {
+		layer, err := crane.Layer(files)
+		require.NoError(t, err)
+		result = append(result, layer)
+	}
+	pushTestLayers(t, tag, result...)
+}
+
+// pushHistoryImage pushes image, which creation time is set only in history like for porter builds.
+func pushHistoryImage(t *testing.T, tag string, created time.Time, files map[string][]byte) {
+	layer, err := crane.Layer(files)
+	require.NoError(t, err)
+	image, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			Created: v1.Time{Time: created},
+		},
+	})
+	require.NoError(t, err)
+	ref, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, image))
+}
+
+func pushTestLayers(t *testing.T, tag string, layers ...v1.Layer) {
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	require.NoError(t, err)
+	ref, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, image))
+}
+
+type tarEntry struct {
+	tar.Header
+	Data string
+}
+
+// tarLayer creates layer with entries in given order, size of regular files is set by data.
+func tarLayer(t *testing.T, entries ...tarEntry) v1.Layer {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := entry.Header
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.Data))
+		}
+		require.NoError(t, w.WriteHeader(&header))
+		_, err := w.Write([]byte(entry.Data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	require.NoError(t, err)
+	return layer
+}
+
+func TestPushSkipExisting(t *testing.T) {
+	host := newTestRegistry(t)
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	first := host + "/foo:latest"
+	buildTestImage(t, state, first)
+
+	results, err := state.Push(ctx, first)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, results[0].Size, results[0].Uploaded)
+
+	second := host + "/bar:latest"
+	require.NoError(t, state.Tag(ctx, first, second))
+
+	results, err = state.Push(ctx, second)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, int64(0), results[0].Uploaded)
+}
+
+func TestPushMountsBlobs(t *testing.T) {
+	host, mounts := newMountRegistry(t)
+	pushTestImage(t, host+"/base:latest", map[string][]byte{"a.txt": []byte("a")})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// Built blobs are uploaded once and mounted to other repositories
+	buildTestImage(t, state, host+"/foo:latest")
+	results, err := state.Push(ctx, host+"/foo:latest")
+	require.NoError(t, err)
+	assert.Equal(t, results[0].Size, results[0].Uploaded)
+	assert.Equal(t, int32(0), mounts.Load())
+
+	require.NoError(t, state.Tag(ctx, host+"/foo:latest", host+"/bar:latest"))
+	results, err = state.Push(ctx, host+"/bar:latest")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), results[0].Uploaded)
+	assert.Equal(t, int32(2), mounts.Load())
+
+	// Pulled blobs are mounted from source repository
+	base, err := name.ParseReference(host + "/base:latest")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, base, false)
+	require.NoError(t, err)
+	require.NoError(t, state.Tag(ctx, base.Name(), host+"/copy:latest"))
+	results, err = state.Push(ctx, host+"/copy:latest")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), results[0].Uploaded)
+	assert.Equal(t, int32(4), mounts.Load())
+}
+
+func TestUploadedCountsLastAttempt(t *testing.T) {
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	buildTestImage(t, state, "local/foo:latest")
+	image, err := name.ParseReference("local/foo:latest")
+	require.NoError(t, err)
+	manifest, err := state.LoadManifest(ctx, image)
+	require.NoError(t, err)
+
+	stateImage := state.NewImage(ctx, manifest)
+	layers, err := stateImage.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	// Layer is read again on upload retry
+	for i := 0; i < 2; i++ {
+		r, err := layers[0].Compressed()
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+	}
+	uploaded := stateImage.(interface{ Uploaded() int64 }).Uploaded()
+	assert.Equal(t, manifest.Layers[0].Size, uploaded)
+}
+
+func TestCopyKeepDigest(t *testing.T) {
+	sourceHost := newTestRegistry(t)
+	targetHost := newTestRegistry(t)
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	source := sourceHost + "/foo:latest"
+	buildTestImage(t, state, source)
+	_, err = state.Push(ctx, source)
+	require.NoError(t, err)
+
+	// Copy with clean cache
+	state, err = src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	target := targetHost + "/bar:latest"
+	hash, err := state.Copy(ctx, source, target, false)
+	require.NoError(t, err)
+
+	targetRef, err := name.ParseReference(target)
+	require.NoError(t, err)
+	desc, err := remote.Head(targetRef)
+	require.NoError(t, err)
+	assert.Equal(t, hash, desc.Digest)
+}
+
+func TestCopyManifestList(t *testing.T) {
+	sourceHost := newTestRegistry(t)
+	targetHost := newTestRegistry(t)
+
+	var index v1.ImageIndex = empty.Index
+	for _, platform := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		layer, err := crane.Layer(map[string][]byte{"arch.txt": []byte(platform.Architecture)})
+		require.NoError(t, err)
+		image, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{OS: platform.OS, Architecture: platform.Architecture})
+		require.NoError(t, err)
+		image, err = mutate.AppendLayers(image, layer)
+		require.NoError(t, err)
+		platform := platform
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: &platform},
+		})
+	}
+	source, err := name.ParseReference(sourceHost + "/foo:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(source, index))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// Without all platforms single platform image is copied with warning
+	hook := logtest.NewGlobal()
+	t.Cleanup(hook.Reset)
+	target, err := name.ParseReference(targetHost + "/foo:single")
+	require.NoError(t, err)
+	_, err = state.Copy(ctx, source.Name(), target.Name(), false)
+	require.NoError(t, err)
+	desc, err := remote.Head(target)
+	require.NoError(t, err)
+	assert.False(t, desc.MediaType.IsIndex())
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "linux/amd64")
+	assert.Contains(t, warnings[0], "--all-platforms")
+
+	target, err = name.ParseReference(targetHost + "/foo:all")
+	require.NoError(t, err)
+	hash, err := state.Copy(ctx, source.Name(), target.Name(), true)
+	require.NoError(t, err)
+	desc, err = remote.Head(target)
+	require.NoError(t, err)
+	assert.True(t, desc.MediaType.IsIndex())
+	assert.Equal(t, hash, desc.Digest)
+
+	// Copy can be cancelled
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = state.Copy(cancelled, source.Name(), targetHost+"/foo:cancelled", true)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPullRewriteAndMirror(t *testing.T) {
+	upstreamHost := newTestRegistry(t)
+	mirrorHost := newTestRegistry(t)
+
+	ctx := context.Background()
+
+	// Image exists only in mirror, so pull succeeds only if mirror is tried
+	pushTestImage(t, mirrorHost+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+registries:
+  example.invalid:
+    rewrite:
+      "*": `+upstreamHost+`/*
+  `+upstreamHost+`:
+    mirrors:
+      - `+mirrorHost+`
+`), 0644))
+
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	image, err := name.ParseReference("example.invalid/foo:latest")
+	require.NoError(t, err)
+	assert.Equal(t, upstreamHost+"/foo:latest", state.RemoteReference(image).Name())
+
+	mirrors := state.MirrorReferences(image)
+	require.Len(t, mirrors, 2)
+	assert.Equal(t, mirrorHost+"/foo:latest", mirrors[0].Name())
+
+	manifest, err := state.Pull(ctx, image, false)
+	require.NoError(t, err)
+	mirrored, err := remote.Image(mirrors[0])
+	require.NoError(t, err)
+	mirroredConfig, err := mirrored.ConfigName()
+	require.NoError(t, err)
+	assert.Equal(t, mirroredConfig.String(), manifest.Config.Digest.String())
+}
+
+func TestGetRemoteWithoutLayers(t *testing.T) {
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")}, map[string][]byte{"b.txt": []byte("b")})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/foo:latest")
+	require.NoError(t, err)
+	remoteImage, err := state.GetRemote(ctx, image, nil)
+	require.NoError(t, err)
+	require.Nil(t, remoteImage.Index)
+	require.NotNil(t, remoteImage.Manifest)
+	assert.Len(t, remoteImage.Manifest.Layers, 2)
+	assert.Len(t, remoteImage.ConfigFile.RootFS.DiffIDs, 2)
+	assert.NotEmpty(t, remoteImage.RawConfig)
+
+	// Remote inspect must not change local cache
+	manifest, err := state.LoadManifest(ctx, image)
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+}
+
+func TestListTagsAndCatalog(t *testing.T) {
+	host := newTestRegistry(t)
+	for _, tag := range []string{"1.0.0", "1.10.0", "1.2.0", "v2.0.0-rc1", "latest", "dev"} {
+		pushTestImage(t, host+"/foo:"+tag)
+	}
+	pushTestImage(t, host+"/bar/baz:latest")
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	repo, err := name.NewRepository(host + "/foo")
+	require.NoError(t, err)
+	tags, err := state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v2.0.0-rc1", "1.10.0", "1.2.0", "1.0.0", "dev", "latest"}, tags)
+
+	tags, err = state.ListTags(ctx, repo, "1.*", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.10.0", "1.2.0"}, tags)
+
+	registry, err := name.NewRegistry(host)
+	require.NoError(t, err)
+	repos, err := state.Catalog(ctx, registry)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar/baz", "foo"}, repos)
+}
+
+func TestRetention(t *testing.T) {
+	host := newTestRegistry(t)
+	now := time.Now()
+	for i, tag := range []string{"ci-1", "ci-2", "ci-3", "ci-4", "release"} {
+		layer, err := crane.Layer(map[string][]byte{"tag.txt": []byte(tag)})
+		require.NoError(t, err)
+		image, err := mutate.AppendLayers(empty.Image, layer)
+		require.NoError(t, err)
+		image, err = mutate.CreatedAt(image, v1.Time{Time: now.Add(time.Duration(i-10) * 24 * time.Hour)})
+		require.NoError(t, err)
+		ref, err := name.ParseReference(host + "/foo:" + tag)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, image))
+	}
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	repo, err := name.NewRepository(host + "/foo")
+	require.NoError(t, err)
+	policy := src.RetentionPolicy{
+		Pattern:   "ci-*",
+		KeepLast:  1,
+		OlderThan: 8*24*time.Hour + 12*time.Hour,
+	}
+	items, err := state.Retention(ctx, repo, policy, true)
+	require.NoError(t, err)
+	deleted := make([]string, 0)
+	for _, item := range items {
+		if item.Delete {
+			deleted = append(deleted, item.Tag)
+		}
+	}
+	assert.Equal(t, []string{"ci-2", "ci-1"}, deleted)
+
+	tags, err := state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, tags, 5)
+
+	_, err = state.Retention(ctx, repo, policy, false)
+	require.NoError(t, err)
+	tags, err = state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci-3", "ci-4", "release"}, tags)
+
+	image, err := name.ParseReference(host + "/foo:ci-3")
+	require.NoError(t, err)
+	_, err = state.Delete(ctx, image, false)
+	require.NoError(t, err)
+	tags, err = state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci-4", "release"}, tags)
+}
+
+func TestDeleteSharedManifest(t *testing.T) {
+	host := newTestRegistry(t)
+	created := time.Now().Add(-time.Hour)
+	pushHistoryImage(t, host+"/foo:1.0", created, map[string][]byte{"a.txt": []byte("a")})
+	pushHistoryImage(t, host+"/foo:latest", created, map[string][]byte{"a.txt": []byte("a")})
+	pushHistoryImage(t, host+"/foo:ci-1", created, map[string][]byte{"a.txt": []byte("a")})
+	pushHistoryImage(t, host+"/foo:ci-2", created, map[string][]byte{"b.txt": []byte("b")})
+	// Image without creation time is never deleted
+	pushTestImage(t, host+"/foo:ci-3", map[string][]byte{"c.txt": []byte("c")})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	repo, err := name.NewRepository(host + "/foo")
+	require.NoError(t, err)
+
+	// Manifest of ci-1 is referenced by tags outside of policy
+	items, err := state.Retention(ctx, repo, src.RetentionPolicy{Pattern: "ci-*", OlderThan: time.Nanosecond}, false)
+	require.NoError(t, err)
+	deleted := make(map[string]bool)
+	for _, item := range items {
+		deleted[item.Tag] = item.Delete
+	}
+	assert.Equal(t, map[string]bool{"ci-1": false, "ci-2": true, "ci-3": false}, deleted)
+	tags, err := state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "ci-1", "ci-3", "latest"}, tags)
+
+	image, err := name.ParseReference(host + "/foo:1.0")
+	require.NoError(t, err)
+	_, err = state.Delete(ctx, image, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ci-1, latest")
+
+	digest, err := state.Delete(ctx, image, true)
+	require.NoError(t, err)
+	_, err = remote.Head(digest)
+	assert.Error(t, err)
+	tags, err = state.ListTags(ctx, repo, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci-3"}, tags)
+}
+
+func TestRetentionStaleMirror(t *testing.T) {
+	upstreamHost := newTestRegistry(t)
+	mirrorHost := newTestRegistry(t)
+
+	// Mirror has outdated release tag and no CI tags
+	created := time.Now().Add(-time.Hour)
+	pushHistoryImage(t, upstreamHost+"/foo:release", created, map[string][]byte{"a.txt": []byte("a")})
+	pushHistoryImage(t, upstreamHost+"/foo:ci-1", created, map[string][]byte{"a.txt": []byte("a")})
+	pushHistoryImage(t, upstreamHost+"/foo:ci-2", created, map[string][]byte{"b.txt": []byte("b")})
+	pushTestImage(t, mirrorHost+"/foo:release", map[string][]byte{"old.txt": []byte("old")})
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+registries:
+  `+upstreamHost+`:
+    mirrors:
+      - `+mirrorHost+`
+`), 0644))
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	repo, err := name.NewRepository(upstreamHost + "/foo")
+	require.NoError(t, err)
+	items, err := state.Retention(ctx, repo, src.RetentionPolicy{Pattern: "ci-*", OlderThan: time.Nanosecond}, false)
+	require.NoError(t, err)
+	deleted := make(map[string]bool)
+	for _, item := range items {
+		deleted[item.Tag] = item.Delete
+	}
+	assert.Equal(t, map[string]bool{"ci-1": false, "ci-2": true}, deleted)
+
+	tags, err := remote.List(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci-1", "release"}, tags)
+}
+
+func TestPullRecordsDigest(t *testing.T) {
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:1.2", map[string][]byte{"a.txt": []byte("a")})
+
+	// Cache cleanup requires disk cache
+	config := defaultConfig
+	config.CacheDir = t.TempDir()
+	config.MemoryCache = false
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/foo:1.2")
+	require.NoError(t, err)
+	manifest, err := state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	resolved, err := state.Resolve(ctx, image, true)
+	require.NoError(t, err)
+	desc, err := remote.Head(image)
+	require.NoError(t, err)
+	assert.Equal(t, desc.Digest.String(), resolved.DigestStr())
+
+	cached, err := state.LoadManifest(ctx, resolved)
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+	assert.Equal(t, manifest.Config.Digest, cached.Config.Digest)
+
+	repoDigests, err := state.RepoDigests(ctx, manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []name.Digest{resolved}, repoDigests)
+
+	images, err := state.ListImages(ctx)
+	require.NoError(t, err)
+	assert.Len(t, images, 1)
+
+	require.NoError(t, state.Remove(ctx, image.Name()))
+	cached, err = state.LoadManifest(ctx, resolved)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+
+	// Explicitly pulled digest reference is kept on tag removal
+	_, err = state.Pull(ctx, resolved, false)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+	require.NoError(t, state.Remove(ctx, image.Name()))
+	cached, err = state.LoadManifest(ctx, resolved)
+	require.NoError(t, err)
+	assert.NotNil(t, cached)
+	_, err = state.ImageFS(ctx, resolved)
+	require.NoError(t, err)
+}
+
+func TestOffline(t *testing.T) {
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+
+	config := defaultConfig
+	config.CacheDir = t.TempDir()
+	config.MemoryCache = false
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/foo:latest")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	config.Offline = true
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+
+	// Cached image is available without registry
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	missing, err := name.ParseReference(host + "/foo:missing")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, missing, true)
+	require.Error(t, err)
+	assert.True(t, errorx.IsOfType(err, src.ErrOffline))
+	assert.Contains(t, err.Error(), missing.Name())
+
+	_, err = state.Push(ctx, image.Name())
+	assert.True(t, errorx.IsOfType(err, src.ErrOffline))
+
+	_, err = state.ListTags(ctx, image.Context(), "", 0)
+	assert.True(t, errorx.IsOfType(err, src.ErrOffline))
+}
+
+func TestManifestTTL(t *testing.T) {
+	host := newTestRegistry(t)
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+
+	config := defaultConfig
+	config.CacheDir = t.TempDir()
+	config.MemoryCache = false
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/foo:latest")
+	require.NoError(t, err)
+	first, err := state.PullManifest(ctx, image, true)
+	require.NoError(t, err)
+
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"b.txt": []byte("b")})
+
+	// Cached manifest never expires by default
+	cached, err := state.PullManifest(ctx, image, true)
+	require.NoError(t, err)
+	assert.Equal(t, first.Config.Digest, cached.Config.Digest)
+
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+manifestttl: 24h
+registries:
+  `+host+`:
+    repositoryttl:
+      "f*": 1ns
+`), 0644))
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+
+	refreshed, err := state.PullManifest(ctx, image, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Config.Digest, refreshed.Config.Digest)
+
+	// Build with --pull refreshes base image
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"c.txt": []byte("c")})
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM "+image.Name()+"\n"), 0644))
+	config.ConfigFile = ""
+	state, err = src.NewState(config)
+	require.NoError(t, err)
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:latest", Pull: true}, contextDir)
+	require.NoError(t, err)
+
+	latest, err := remote.Image(image)
+	require.NoError(t, err)
+	latestConfig, err := latest.ConfigName()
+	require.NoError(t, err)
+	cached, err = state.LoadManifest(ctx, image)
+	require.NoError(t, err)
+	assert.Equal(t, latestConfig.String(), cached.Config.Digest.String())
+}
+
+func TestManifestTTLReportsUpdate(t *testing.T) {
+	host := newTestRegistry(t)
+	base := host + "/base:latest"
+	pushTestImage(t, base, map[string][]byte{"a.txt": []byte("a")})
+
+	config := defaultConfig
+	config.CacheDir = t.TempDir()
+	config.MemoryCache = false
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+manifestttl: 1ns
+`), 0644))
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM "+base+"\n"), 0644))
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:latest"}, contextDir)
+	require.NoError(t, err)
+
+	// Expired base image is refreshed on build and change is reported
+	pushTestImage(t, base, map[string][]byte{"b.txt": []byte("b")})
+	hook := logtest.NewGlobal()
+	t.Cleanup(hook.Reset)
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:latest"}, contextDir)
+	require.NoError(t, err)
+
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "image "+base+" updated")
+}
+
+func TestManifestTTLRegistryUnavailable(t *testing.T) {
+	var down atomic.Bool
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := u.Host
+
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+
+	config := defaultConfig
+	config.CacheDir = t.TempDir()
+	config.MemoryCache = false
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+manifestttl: 1ns
+maxretries: -1
+`), 0644))
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/foo:latest")
+	require.NoError(t, err)
+	first, err := state.PullManifest(ctx, image, true)
+	require.NoError(t, err)
+
+	// Expired manifest is used while registry is unavailable
+	down.Store(true)
+	cached, err := state.PullManifest(ctx, image, true)
+	require.NoError(t, err)
+	assert.Equal(t, first.Config.Digest, cached.Config.Digest)
+
+	// Explicit refresh still fails
+	_, err = state.PullManifest(ctx, image, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrRegistryUnavailable), "unexpected error: %v", err)
+}
+
+// slowReader delays each read to emulate slow upload.
+type slowReader struct {
+	io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.Reader.Read(p)
+}
+
+func TestTimeoutSlowUpload(t *testing.T) {
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPatch || (r.Method == http.MethodPut && r.ContentLength != 0):
+			// Read body before registry handler, which locks all uploads
+			data, err := io.ReadAll(&slowReader{Reader: r.Body, delay: 100 * time.Millisecond})
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/slow/"):
+			time.Sleep(200 * time.Millisecond)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := u.Host
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+maxretries: -1
+timeout: 50ms
+`), 0644))
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// Upload isn't limited by response timeout
+	buildTestImage(t, state, host+"/foo:latest")
+	_, err = state.Push(ctx, host+"/foo:latest")
+	require.NoError(t, err)
+
+	// Waiting for response is limited
+	slow, err := name.ParseReference(host + "/slow:latest")
+	require.NoError(t, err)
+	_, err = state.PullManifest(ctx, slow, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrRegistryUnavailable), "unexpected error: %v", err)
+}
+
+func TestRetryDisabled(t *testing.T) {
+	var requests atomic.Int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/broken/") {
+			requests.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := u.Host
+
+	config := defaultConfig
+	config.ConfigFile = path.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config.ConfigFile, []byte(`
+maxretries: -1
+`), 0644))
+	state, err := src.NewState(config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/broken:latest")
+	require.NoError(t, err)
+	_, err = state.PullManifest(ctx, image, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrRegistryUnavailable), "unexpected error: %v", err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	requests.Store(0)
+	buildTestImage(t, state, image.Name())
+	_, err = state.Push(ctx, image.Name())
+	assert.True(t, errorx.IsOfType(err, src.ErrRegistryUnavailable), "unexpected error: %v", err)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRetryAndErrorTypes(t *testing.T) {
+	var throttle atomic.Bool
+	var throttled atomic.Int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case throttle.Load() && strings.HasPrefix(r.URL.Path, "/v2/flaky/") && throttled.Add(1) <= 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case strings.HasPrefix(r.URL.Path, "/v2/private/"):
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/v2/_catalog":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := u.Host
+
+	pushTestImage(t, host+"/foo:latest", map[string][]byte{"a.txt": []byte("a")})
+	pushTestImage(t, host+"/flaky:latest", map[string][]byte{"a.txt": []byte("a")})
+	throttle.Store(true)
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	image, err := name.ParseReference(host + "/flaky:latest")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+	assert.Greater(t, throttled.Load(), int32(2))
+
+	missing, err := name.ParseReference(host + "/foo:missing")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, missing, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrImageNotFound), "unexpected error: %v", err)
+
+	// Manifest HEAD response has no error code
+	_, err = state.Delete(ctx, missing, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrImageNotFound), "unexpected error: %v", err)
+
+	// Unknown repository and missing endpoint aren't reported as missing image
+	_, err = state.ListTags(ctx, missing.Context().Registry.Repo("unknown"), "", 0)
+	assert.True(t, errorx.IsOfType(err, src.ErrNotFound), "unexpected error: %v", err)
+	_, err = state.Catalog(ctx, missing.Context().Registry)
+	assert.True(t, errorx.IsOfType(err, src.ErrNotFound), "unexpected error: %v", err)
+
+	private, err := name.ParseReference(host + "/private:latest")
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, private, false)
+	assert.True(t, errorx.IsOfType(err, src.ErrAuth), "unexpected error: %v", err)
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/joomcode/go-porter/src"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquash(t *testing.T) {
+	host := newTestRegistry(t)
+	tag := host + "/foo:latest"
+	pushTestImage(t, tag, map[string][]byte{
+		"a.txt":     []byte("removed"),
+		"dir/b.txt": []byte("old"),
+		"c.txt":     []byte("kept"),
+	}, map[string][]byte{
+		"dir/b.txt": []byte("middle"),
+		"d.txt":     []byte("added"),
+	}, map[string][]byte{
+		".wh.a.txt": nil,
+		"dir/b.txt": []byte("new"),
+	})
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	image, err := name.ParseReference(tag)
+	require.NoError(t, err)
+	_, err = state.Pull(ctx, image, false)
+	require.NoError(t, err)
+
+	target, err := name.ParseReference(host + "/foo:squashed")
+	require.NoError(t, err)
+	manifest, err := state.Squash(ctx, image, target, 1)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 2)
+
+	blob, err := state.ReadBlob(ctx, manifest.Config)
+	require.NoError(t, err)
+	var configFile v1.ConfigFile
+	require.NoError(t, json.Unmarshal(blob, &configFile))
+	assert.Len(t, configFile.RootFS.DiffIDs, 2)
+
+	// Squashed layer contains only changes relative to first layer
+	r, err := state.OpenLayer(ctx, manifest.Layers[1])
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, map[string]string{
+		".wh.a.txt": "",
+		"d.txt":     "added",
+		"dir/":      "",
+		"dir/b.txt": "new",
+	}, readTar(t, r))
+
+	var expected, actual bytes.Buffer
+	require.NoError(t, state.Export(ctx, image, &expected))
+	require.NoError(t, state.Export(ctx, target, &actual))
+	assert.Equal(t, readTar(t, &expected), readTar(t, &actual))
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(data)
+	}
+	return files
+}
+
+func TestBuildSquash(t *testing.T) {
+	host := newTestRegistry(t)
+	base := host + "/base:latest"
+	pushTestImage(t, base, map[string][]byte{"base.txt": []byte("base")})
+
+	contextDir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "Dockerfile"), []byte("FROM "+base+"\nCOPY a.txt /\nCOPY b.txt /dir/\n"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(contextDir, "b.txt"), []byte("b"), 0644))
+
+	state, err := src.NewState(defaultConfig)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// All instructions are written to single layer on top of base
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:layers"}, contextDir)
+	require.NoError(t, err)
+	layers, err := name.ParseReference("local/foo:layers")
+	require.NoError(t, err)
+	manifest, err := state.LoadManifest(ctx, layers)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 2)
+
+	r, err := state.OpenLayer(ctx, manifest.Layers[1])
+	require.NoError(t, err)
+	defer r.Close()
+	files := readTar(t, r)
+	assert.Equal(t, "a", files["a.txt"])
+	assert.Equal(t, "b", files["dir/b.txt"])
+	assert.NotContains(t, files, "base.txt")
+
+	// Single built layer has nothing to squash
+	_, err = state.Build(ctx, TestBuildArgs{Tag: "local/foo:squashed", Squash: true}, contextDir)
+	require.NoError(t, err)
+	squashed, err := name.ParseReference("local/foo:squashed")
+	require.NoError(t, err)
+	squashedManifest, err := state.LoadManifest(ctx, squashed)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Layers, squashedManifest.Layers)
+
+	var expected, actual bytes.Buffer
+	require.NoError(t, state.Export(ctx, layers, &expected))
+	require.NoError(t, state.Export(ctx, squashed, &actual))
+	assert.Equal(t, readTar(t, &expected), readTar(t, &actual))
+}